@@ -0,0 +1,340 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	"github.com/rancher/norman/objectclient"
+	log "github.com/sirupsen/logrus"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	"k8s.io/api/autoscaling/v2beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/jsonmergepatch"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// applyRetries how many times a patch is recomputed against a fresh object on conflict
+const applyRetries = 3
+
+// applyTarget is one kind the apply engine writes
+type applyTarget struct {
+	client *objectclient.ObjectClient
+	// get read the live object from the informer cache
+	get func(namespace, name string) (runtime.Object, error)
+	// patchMeta of builtin kinds, kinds without it (crds) use json merge patch
+	patchMeta strategicpatch.LookupPatchMeta
+	// released fields may be handed over to another actor by leaving them out of desired,
+	// e.g. replicas of a deployment scaled by hpa. They are never deleted by the patch.
+	released [][]string
+}
+
+func newApplyTarget(client *objectclient.ObjectClient, get func(namespace, name string) (runtime.Object, error), dataStruct interface{}, released ...[]string) *applyTarget {
+	target := &applyTarget{client: client, get: get, released: released}
+	if dataStruct != nil {
+		patchMeta, err := strategicpatch.NewPatchMetaFromStruct(dataStruct)
+		if err != nil {
+			panic(err)
+		}
+		target.patchMeta = patchMeta
+	}
+	return target
+}
+
+// newApplyTargets register every generated kind, keyed by kind
+func (c *controller) newApplyTargets() map[string]*applyTarget {
+	return map[string]*applyTarget{
+		ConfigMapKind.Kind: newApplyTarget(c.configmapClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.configmapLister.Get(namespace, name)
+		}, corev1.ConfigMap{}),
+		SecretKind.Kind: newApplyTarget(c.secretClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.secretLister.Get(namespace, name)
+		}, corev1.Secret{}),
+		PersistentVolumeClaimKind.Kind: newApplyTarget(c.claimClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.claimLister.Get(namespace, name)
+		}, corev1.PersistentVolumeClaim{}),
+		DeploymentKind.Kind: newApplyTarget(c.deploymentClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.deploymentLister.Get(namespace, name)
+		}, appsv1beta2.Deployment{}, []string{"spec", "replicas"}),
+		StatefulSetKind.Kind: newApplyTarget(c.statefulSetClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.statefulSetLister.Get(namespace, name)
+		}, appsv1beta2.StatefulSet{}, []string{"spec", "replicas"}),
+		DaemonSetKind.Kind: newApplyTarget(c.daemonSetClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.daemonSetLister.Get(namespace, name)
+		}, appsv1beta2.DaemonSet{}),
+		JobKind.Kind: newApplyTarget(c.jobClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.jobLister.Get(namespace, name)
+		}, batchv1.Job{}),
+		CronJobKind.Kind: newApplyTarget(c.cronJobClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.cronJobLister.Get(namespace, name)
+		}, batchv1beta1.CronJob{}),
+		HorizontalPodAutoscalerKind.Kind: newApplyTarget(c.autoscaleClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.autoscaleLister.Get(namespace, name)
+		}, v2beta2.HorizontalPodAutoscaler{}),
+		ServiceKind.Kind: newApplyTarget(c.serviceClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.serviceLister.Get(namespace, name)
+		}, corev1.Service{}),
+		ServiceRoleKind.Kind: newApplyTarget(c.serviceRoleClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.serviceRoleLister.Get(namespace, name)
+		}, nil),
+		ServiceRoleBindingKind.Kind: newApplyTarget(c.serviceRoleBindingClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.serviceRoleBindingLister.Get(namespace, name)
+		}, nil),
+		VirtualServiceKind.Kind: newApplyTarget(c.virtualServiceClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.virtualServiceLister.Get(namespace, name)
+		}, nil),
+		DestinationRuleKind.Kind: newApplyTarget(c.destClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.destLister.Get(namespace, name)
+		}, nil),
+		InstanceKind.Kind: newApplyTarget(c.instanceClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.instanceLister.Get(namespace, name)
+		}, nil),
+		QuotaSpecKind.Kind: newApplyTarget(c.quotaspecClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.quotaspecLister.Get(namespace, name)
+		}, nil),
+		QuotaSpecBindingKind.Kind: newApplyTarget(c.quotaspecbindingClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.quotaspecbindingLister.Get(namespace, name)
+		}, nil),
+		HandlerKind.Kind: newApplyTarget(c.handlerClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.handerLister.Get(namespace, name)
+		}, nil),
+		RuleKind.Kind: newApplyTarget(c.ruleClient.ObjectClient(), func(namespace, name string) (runtime.Object, error) {
+			return c.ruleLister.Get(namespace, name)
+		}, nil),
+	}
+}
+
+// apply create desired or patch the live object with a three-way merge of
+// last-applied (original), desired (modified) and live (current), so fields set by
+// other actors survive. desired must come from a render function, carrying kind
+// and LastAppliedConfigAnnotation. It returns the object in the cluster and the action
+// taken, empty when the live object already matches.
+func (c *controller) apply(app *v3.Application, desired runtime.Object) (runtime.Object, string, error) {
+	kind := desired.GetObjectKind().GroupVersionKind().Kind
+	target, ok := c.appliers[kind]
+	if !ok {
+		return nil, "", fmt.Errorf("kind %s is not supported by apply", kind)
+	}
+	accessor, err := meta.Accessor(desired)
+	if err != nil {
+		return nil, "", err
+	}
+	namespace, name := accessor.GetNamespace(), accessor.GetName()
+
+	live, err := target.get(namespace, name)
+	if errors.IsNotFound(err) {
+		live, err = target.client.Create(desired)
+		if !errors.IsAlreadyExists(err) {
+			if err == nil && c.observed.synced(app.Namespace+"/"+app.Name, app.Generation) {
+				c.recordDrift(app, kind, name, "was deleted out of band, recreated")
+			}
+			c.recordEvent(app, ActionCreate, kind, name, err)
+			if err != nil {
+				log.Errorf("Create %s %s Error : %s", kind, namespace+":"+name, err.Error())
+				return nil, "", err
+			}
+			return live, ActionCreate, nil
+		}
+		// informer cache is behind, patch the existing one
+		live, err = target.client.GetNamespaced(namespace, name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := 0; ; i++ {
+		patch, err := target.patch(desired, live)
+		if err != nil {
+			return nil, "", err
+		}
+		if patch == nil {
+			return live, "", nil
+		}
+		// the desired state is the last applied one, so live was changed by someone else
+		drifted := false
+		if liveAccessor, err := meta.Accessor(live); err == nil {
+			applied := liveAccessor.GetAnnotations()[LastAppliedConfigAnnotation]
+			drifted = applied != "" && applied == accessor.GetAnnotations()[LastAppliedConfigAnnotation]
+		}
+		if kind == SecretKind.Kind {
+			// the patch carries the secret data
+			log.Debugf("Patch %s %s", kind, namespace+":"+name)
+		} else {
+			log.Debugf("Patch %s %s: %s", kind, namespace+":"+name, string(patch))
+		}
+		result, err := target.client.Patch(name, desired, target.patchType(), patch)
+		if errors.IsConflict(err) && i < applyRetries {
+			// live object changed since it was read, recompute against the fresh one
+			live, err = target.client.GetNamespaced(namespace, name, metav1.GetOptions{})
+			if err != nil {
+				return nil, "", err
+			}
+			continue
+		}
+		if err == nil && drifted {
+			c.recordDrift(app, kind, name, "was changed out of band, restored")
+		}
+		c.recordEvent(app, ActionUpdate, kind, name, err)
+		if err != nil {
+			log.Errorf("Update %s %s Error : %s", kind, namespace+":"+name, err.Error())
+			return nil, "", err
+		}
+		return result, ActionUpdate, nil
+	}
+}
+
+// remove delete the object of kind called name when it is controlled by app,
+// objects created by someone else with the same name are kept
+func (c *controller) remove(app *v3.Application, kind, name string) error {
+	target, ok := c.appliers[kind]
+	if !ok {
+		return fmt.Errorf("kind %s is not supported by apply", kind)
+	}
+	live, err := target.get(app.Namespace, name)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(live)
+	if err != nil {
+		return err
+	}
+	if ref := metav1.GetControllerOf(accessor); ref == nil || ref.UID != app.UID {
+		return nil
+	}
+	err = target.client.DeleteNamespaced(app.Namespace, name, &metav1.DeleteOptions{})
+	c.recordEvent(app, ActionDelete, kind, name, err)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Errorf("Delete %s %s Error : %s", kind, app.Namespace+":"+name, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (t *applyTarget) patchType() types.PatchType {
+	if t.patchMeta != nil {
+		return types.StrategicMergePatchType
+	}
+	return types.MergePatchType
+}
+
+// patch compute the three-way patch from live to desired, nil when nothing changes.
+// The patch carries the resourceVersion of live, so it fails with a conflict instead of
+// overwriting changes it was not computed against.
+func (t *applyTarget) patch(desired, live runtime.Object) ([]byte, error) {
+	accessor, err := meta.Accessor(live)
+	if err != nil {
+		return nil, err
+	}
+	var original []byte
+	if applied := accessor.GetAnnotations()[LastAppliedConfigAnnotation]; applied != "" {
+		original, err = normalizeApplied([]byte(applied))
+		if err != nil {
+			// an unreadable annotation is treated as missing, nothing is deleted
+			log.Warnf("Parse %s of %s failed, err: %s", LastAppliedConfigAnnotation, accessor.GetNamespace()+":"+accessor.GetName(), err.Error())
+			original = nil
+		}
+	}
+	modified, err := json.Marshal(desired)
+	if err != nil {
+		return nil, err
+	}
+	modified, err = normalizeApplied(modified)
+	if err != nil {
+		return nil, err
+	}
+	if original != nil && len(t.released) != 0 {
+		original, err = releaseFields(original, modified, t.released)
+		if err != nil {
+			return nil, err
+		}
+	}
+	current, err := json.Marshal(live)
+	if err != nil {
+		return nil, err
+	}
+
+	var patch []byte
+	if t.patchMeta != nil {
+		patch, err = strategicpatch.CreateThreeWayMergePatch(original, modified, current, t.patchMeta, true)
+	} else {
+		patch, err = jsonmergepatch.CreateThreeWayJSONMergePatch(original, modified, current)
+	}
+	if err != nil {
+		return nil, err
+	}
+	patchMap := make(map[string]interface{})
+	err = json.Unmarshal(patch, &patchMap)
+	if err != nil {
+		return nil, err
+	}
+	if len(patchMap) == 0 {
+		return nil, nil
+	}
+	metadata, ok := patchMap["metadata"].(map[string]interface{})
+	if !ok {
+		metadata = make(map[string]interface{})
+		patchMap["metadata"] = metadata
+	}
+	metadata["resourceVersion"] = accessor.GetResourceVersion()
+	return json.Marshal(patchMap)
+}
+
+// normalizeApplied drop status, creationTimestamp and null values which typed objects
+// always serialize, they are not part of what the controller wants to own
+func normalizeApplied(data []byte) ([]byte, error) {
+	object := make(map[string]interface{})
+	err := json.Unmarshal(data, &object)
+	if err != nil {
+		return nil, err
+	}
+	delete(object, "status")
+	object = dropNulls(object).(map[string]interface{})
+	return json.Marshal(object)
+}
+
+// releaseFields remove from original the released fields which modified does not set
+func releaseFields(original, modified []byte, released [][]string) ([]byte, error) {
+	originalMap := make(map[string]interface{})
+	modifiedMap := make(map[string]interface{})
+	if err := json.Unmarshal(original, &originalMap); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(modified, &modifiedMap); err != nil {
+		return nil, err
+	}
+	for _, field := range released {
+		if _, found, _ := unstructured.NestedFieldNoCopy(modifiedMap, field...); !found {
+			unstructured.RemoveNestedField(originalMap, field...)
+		}
+	}
+	return json.Marshal(originalMap)
+}
+
+func dropNulls(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if item == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = dropNulls(item)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = dropNulls(v[i])
+		}
+	}
+	return value
+}
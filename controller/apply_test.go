@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// anyValue expects a field in the patch whatever its value
+const anyValue = "<any>"
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+// newTestDeployment the deployment the controller renders, changed by mutate
+func newTestDeployment(mutate func(*appsv1beta2.Deployment)) *appsv1beta2.Deployment {
+	deploy := &appsv1beta2.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo-web-v1", Labels: map[string]string{"app": "demo", "version": "v1"}},
+		Spec: appsv1beta2.DeploymentSpec{
+			Replicas: int32Ptr(2),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "demo"}},
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{
+					Name:  "web",
+					Image: "nginx:1.15",
+					Env:   []corev1.EnvVar{{Name: "MODE", Value: "prod"}},
+				}}},
+			},
+		},
+	}
+	if mutate != nil {
+		mutate(deploy)
+	}
+	return withApplied(deploy, DeploymentKind).(*appsv1beta2.Deployment)
+}
+
+// patchField the value at path of the patch, found is false when the patch leaves it alone
+func patchField(t *testing.T, patch []byte, path ...string) (interface{}, bool) {
+	patchMap := make(map[string]interface{})
+	if err := json.Unmarshal(patch, &patchMap); err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := unstructured.NestedFieldNoCopy(patchMap, path...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value, found
+}
+
+func TestApplyTargetPatch(t *testing.T) {
+	target := newApplyTarget(nil, nil, appsv1beta2.Deployment{}, []string{"spec", "replicas"})
+	// field is one expectation on the patch, a deleted field is present with value nil
+	type field struct {
+		path    []string
+		present bool
+		value   interface{}
+	}
+	tests := []struct {
+		name    string
+		live    func(*appsv1beta2.Deployment)
+		desired func(*appsv1beta2.Deployment)
+		// noPatch the live object already matches
+		noPatch bool
+		fields  []field
+	}{
+		{name: "unchanged", noPatch: true},
+		{name: "fields of others survive", noPatch: true, live: func(d *appsv1beta2.Deployment) {
+			d.Annotations["deployment.kubernetes.io/revision"] = "3"
+			d.Labels["team"] = "ops"
+			d.Spec.Template.Spec.Containers[0].ImagePullPolicy = corev1.PullAlways
+		}},
+		{name: "label added", desired: func(d *appsv1beta2.Deployment) {
+			d.Labels["tier"] = "front"
+		}, fields: []field{{path: []string{"metadata", "labels", "tier"}, present: true, value: "front"}}},
+		{name: "label removed is deleted", desired: func(d *appsv1beta2.Deployment) {
+			delete(d.Labels, "version")
+		}, fields: []field{{path: []string{"metadata", "labels", "version"}, present: true, value: nil}}},
+		{name: "out of band image restored", live: func(d *appsv1beta2.Deployment) {
+			d.Spec.Template.Spec.Containers[0].Image = "nginx:latest"
+		}, fields: []field{{path: []string{"spec", "template", "spec", "containers"}, present: true, value: anyValue}}},
+		{name: "replicas released to hpa", desired: func(d *appsv1beta2.Deployment) {
+			d.Spec.Replicas = nil
+		}, live: func(d *appsv1beta2.Deployment) {
+			d.Spec.Replicas = int32Ptr(5)
+		}, fields: []field{{path: []string{"spec", "replicas"}}}},
+		{name: "replicas restored", live: func(d *appsv1beta2.Deployment) {
+			d.Spec.Replicas = int32Ptr(5)
+		}, fields: []field{{path: []string{"spec", "replicas"}, present: true, value: float64(2)}}},
+		{name: "unreadable last-applied deletes nothing", desired: func(d *appsv1beta2.Deployment) {
+			delete(d.Labels, "version")
+			d.Labels["tier"] = "front"
+		}, live: func(d *appsv1beta2.Deployment) {
+			d.Annotations[LastAppliedConfigAnnotation] = "{"
+		}, fields: []field{
+			{path: []string{"metadata", "labels", "version"}},
+			{path: []string{"metadata", "labels", "tier"}, present: true, value: "front"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			live := newTestDeployment(nil).DeepCopy()
+			live.ResourceVersion = "7"
+			if tt.live != nil {
+				tt.live(live)
+			}
+			patch, err := target.patch(newTestDeployment(tt.desired), live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.noPatch {
+				if patch != nil {
+					t.Errorf("patch = %s, want none", patch)
+				}
+				return
+			}
+			if patch == nil {
+				t.Fatal("patch is empty")
+			}
+			if version, _ := patchField(t, patch, "metadata", "resourceVersion"); version != "7" {
+				t.Errorf("patch resourceVersion = %v, want 7", version)
+			}
+			for _, f := range tt.fields {
+				value, found := patchField(t, patch, f.path...)
+				if found != f.present {
+					t.Errorf("%v in patch = %v, want %v: %s", f.path, found, f.present, patch)
+					continue
+				}
+				if found && f.value != anyValue && !reflect.DeepEqual(value, f.value) {
+					t.Errorf("%v = %v, want %v: %s", f.path, value, f.value, patch)
+				}
+			}
+		})
+	}
+}
+
+// TestApplyTargetMergePatch kinds without patch meta, e.g. istio crds, use a json merge patch
+func TestApplyTargetMergePatch(t *testing.T) {
+	target := newApplyTarget(nil, nil, nil)
+	newConfigMap := func(data map[string]string) runtime.Object {
+		return withApplied(&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "cm"}, Data: data}, ConfigMapKind)
+	}
+	live := newConfigMap(map[string]string{"a": "1", "b": "2"}).(*corev1.ConfigMap)
+	live.Data["c"] = "set by others"
+	live.ResourceVersion = "7"
+	patch, err := target.patch(newConfigMap(map[string]string{"a": "1", "b": "2"}), live)
+	if err != nil || patch != nil {
+		t.Errorf("patch = %s, %v, want none", patch, err)
+	}
+	patch, err = target.patch(newConfigMap(map[string]string{"a": "3"}), live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := patchField(t, patch, "data")
+	if want := map[string]interface{}{"a": "3", "b": nil}; !reflect.DeepEqual(data, want) {
+		t.Errorf("patch data = %v, want %v", data, want)
+	}
+}
+
+// TestWithAppliedCopiesAnnotations withApplied must not write into a map shared with the application
+func TestWithAppliedCopiesAnnotations(t *testing.T) {
+	app := &v3.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo",
+		Annotations: map[string]string{"owner": "ops"}, Labels: map[string]string{"projectId": "p"}}}
+	component := &v3.Component{Name: "web", Version: "v1", Containers: []v3.ComponentContainer{{Name: "web", Image: "nginx"}}}
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared := map[string]string{"owner": "ops"}
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", Annotations: shared}}
+	withApplied(&deploy, DeploymentKind)
+	withApplied(cm, ConfigMapKind)
+	if !reflect.DeepEqual(app.Annotations, map[string]string{"owner": "ops"}) {
+		t.Errorf("application annotations changed: %v", app.Annotations)
+	}
+	if !reflect.DeepEqual(app.Labels, map[string]string{"projectId": "p"}) {
+		t.Errorf("application labels changed: %v", app.Labels)
+	}
+	if _, ok := shared[LastAppliedConfigAnnotation]; ok {
+		t.Error("withApplied wrote into the annotation map of the object passed in")
+	}
+	if cm.Annotations[LastAppliedConfigAnnotation] == "" {
+		t.Error("withApplied did not record the configmap")
+	}
+}
@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Parsing modes of a command, args or exec entry, given as a prefix of the entry.
+// An entry without prefix is split on every single space, as the controller always did.
+const (
+	// CommandVerbatim the rest of the entry is one argument, e.g. "verbatim:sleep 5 && curl localhost"
+	CommandVerbatim = "verbatim:"
+	// CommandShell split the rest into words like a POSIX shell does, without any expansion
+	CommandShell = "shell:"
+)
+
+// getCommand convert the entries of a command, args or exec list into arguments, each entry
+// is parsed according to its mode and may give several arguments
+func getCommand(entries []string) ([]string, error) {
+	var command []string
+	for i, entry := range entries {
+		words, err := splitCommand(entry)
+		if err != nil {
+			return nil, fmt.Errorf("entry %d %s", i, err.Error())
+		}
+		command = append(command, words...)
+	}
+	return command, nil
+}
+
+// splitCommand parse one entry according to its mode prefix
+func splitCommand(entry string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(entry, CommandShell):
+		return shellWords(strings.TrimPrefix(entry, CommandShell))
+	case strings.HasPrefix(entry, CommandVerbatim):
+		return []string{strings.TrimPrefix(entry, CommandVerbatim)}, nil
+	}
+	return strings.Split(entry, " "), nil
+}
+
+// shellWords split s on unquoted blanks. Single quotes keep everything up to the closing quote,
+// inside double quotes a backslash only escapes $ ` " \ and newline, outside of quotes it escapes
+// any character and a backslash-newline joins the lines. Quoted empty strings are arguments.
+func shellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	// inWord a word was started, possibly by an empty quoted string
+	inWord := false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case r == '\'':
+			end := indexRune(runes, i+1, '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("has an unterminated single quote at offset %d", i)
+			}
+			word.WriteString(string(runes[i+1 : end]))
+			inWord = true
+			i = end
+		case r == '"':
+			start := i
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) && strings.ContainsRune("$`\"\\\n", runes[i+1]) {
+					i++
+					if runes[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, fmt.Errorf("has an unterminated double quote at offset %d", start)
+			}
+			inWord = true
+		case r == '\\':
+			if i+1 == len(runes) {
+				return nil, fmt.Errorf("ends with an unescaped backslash")
+			}
+			i++
+			if runes[i] != '\n' {
+				word.WriteRune(runes[i])
+				inWord = true
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+func indexRune(runes []rune, from int, r rune) int {
+	for i := from; i < len(runes); i++ {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
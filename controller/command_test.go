@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestShellWords(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: nil},
+		{name: "blanks", in: " \t\n", want: nil},
+		{name: "words", in: "sh -c  date", want: []string{"sh", "-c", "date"}},
+		{name: "single quotes", in: `sh -c 'sleep 5 && curl localhost'`, want: []string{"sh", "-c", "sleep 5 && curl localhost"}},
+		{name: "single quotes keep backslash", in: `'a\b'`, want: []string{`a\b`}},
+		{name: "double quotes", in: `echo "a b" c`, want: []string{"echo", "a b", "c"}},
+		{name: "double quote escapes", in: `"\$x \"y\" \\ \a"`, want: []string{`$x "y" \ \a`}},
+		{name: "double quote line continuation", in: "\"a\\\nb\"", want: []string{"ab"}},
+		{name: "backslash escapes blank", in: `a\ b`, want: []string{"a b"}},
+		{name: "backslash line continuation", in: "a\\\nb", want: []string{"ab"}},
+		{name: "empty quoted strings", in: `'' ""`, want: []string{"", ""}},
+		{name: "adjacent quotes join", in: `a'b'"c"`, want: []string{"abc"}},
+		{name: "no expansion", in: `$HOME *`, want: []string{"$HOME", "*"}},
+		{name: "unterminated single quote", in: `echo 'a`, wantErr: true},
+		{name: "unterminated double quote", in: `echo "a`, wantErr: true},
+		{name: "trailing backslash", in: `echo \`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := shellWords(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("shellWords(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shellWords(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		// entries without prefix are split like strings.Split(entry, " ") of the controller before modes existed
+		{name: "space split by default", in: "sleep 5", want: []string{"sleep", "5"}},
+		{name: "default keeps quotes", in: "/bin/sh -c 'run it'", want: []string{"/bin/sh", "-c", "'run", "it'"}},
+		{name: "default keeps empty words", in: "a  b ", want: []string{"a", "", "b", ""}},
+		{name: "default splits on spaces only", in: "a\tb\nc", want: []string{"a\tb\nc"}},
+		{name: "empty", in: "", want: []string{""}},
+		{name: "verbatim prefix", in: "verbatim:sleep 5 && curl localhost", want: []string{"sleep 5 && curl localhost"}},
+		{name: "verbatim prefix keeps the rest", in: "verbatim:shell:x", want: []string{"shell:x"}},
+		{name: "shell prefix", in: `shell:sh -c 'sleep 5'`, want: []string{"sh", "-c", "sleep 5"}},
+		{name: "shell prefix unbalanced", in: `shell:it's`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommand(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitCommand(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitCommand(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetCommand(t *testing.T) {
+	got, err := getCommand([]string{"/bin/sh -c", "verbatim:sleep 5 && curl localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"/bin/sh", "-c", "sleep 5 && curl localhost"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getCommand = %q, want %q", got, want)
+	}
+	got, err = getCommand([]string{"shell:python app.py", "--name", "shell:'a b' c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"python", "app.py", "--name", "a b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("getCommand = %q, want %q", got, want)
+	}
+	if _, err := getCommand([]string{"ok", "shell:'"}); err == nil {
+		t.Error("getCommand accepted an unbalanced shell: entry")
+	}
+}
@@ -3,7 +3,10 @@ package controller
 import (
 	"os"
 	//"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"sort"
 
 	corev1 "k8s.io/api/core/v1"
 	//"k8s.io/apimachinery/pkg/runtime"
@@ -99,3 +102,29 @@ func GetObjectApplied(obj interface{}) string {
 	b, _ := json.Marshal(obj)
 	return string(b)
 }
+
+// GetDataChecksum sha256 of the keys and values of data, stands for secret data in annotations
+func GetDataChecksum(data map[string][]byte) string {
+	var keys []string
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	hash := sha256.New()
+	for _, k := range keys {
+		hash.Write([]byte(k))
+		hash.Write([]byte{0})
+		hash.Write(data[k])
+		hash.Write([]byte{0})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// copyStringMap return a copy of m, never nil
+func copyStringMap(m map[string]string) map[string]string {
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = v
+	}
+	return result
+}
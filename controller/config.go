@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Config file sources, given as the fromParam of a config entry.
+// An entry without fromParam is a key of the configmap of the component.
+const (
+	// ConfigSensitive the value is a key of the secret of the component instead, the last-applied annotation only has its checksum
+	ConfigSensitive = "sensitive"
+	// ConfigSecretKey secretKeyRef:<secret>/<key>, the file is a key of an existing secret of the namespace, value is not needed
+	ConfigSecretKey = EnvSourceSecretKey
+)
+
+// parseConfigSource parse the fromParam of a config entry, the source is empty for a configmap key
+func parseConfigSource(file v3.ConfigFile) (envSource, error) {
+	switch {
+	case file.FromParam == "":
+		return envSource{}, nil
+	case file.FromParam == ConfigSensitive:
+		return envSource{source: ConfigSensitive}, nil
+	case strings.HasPrefix(file.FromParam, ConfigSecretKey+":"):
+		return parseEnvSource(file.FromParam)
+	}
+	return envSource{}, fmt.Errorf("must be %s or %s:<name>/<key>", ConfigSensitive, ConfigSecretKey)
+}
+
+// configMounted the entry has a file to mount, an inline one needs a value
+func configMounted(file v3.ConfigFile) bool {
+	if file.FileName == "" || file.Path == "" {
+		return false
+	}
+	return file.Value != "" || strings.HasPrefix(file.FromParam, ConfigSecretKey+":")
+}
+
+// configSecretName name of the secret holding the sensitive config files of the component version
+func configSecretName(component *v3.Component, app *v3.Application) string {
+	return app.Name + "-" + component.Name + "-" + component.Version + "-" + "secret"
+}
+
+// configVolumeName volume names are DNS-1123 labels, dots of a version like v1.0.12 are replaced as well
+func configVolumeName(component *v3.Component, fileName string) string {
+	return component.Name + "-" + strings.Replace(component.Version, ".", "-", -1) + "-" + strings.Replace(strings.Replace(fileName, ".", "-", -1), "_", "-", -1)
+}
+
+// getConfigVolumes the pod volumes of the config files of component, each file is mounted by subPath path/to/<fileName>
+func getConfigVolumes(component *v3.Component, app *v3.Application) ([]corev1.Volume, error) {
+	var volumes []corev1.Volume
+	for _, container := range component.Containers {
+		for _, k := range container.Config {
+			if !configMounted(k) || hasVolume(volumes, configVolumeName(component, k.FileName)) {
+				continue
+			}
+			s, err := parseConfigSource(k)
+			if err != nil {
+				return nil, fmt.Errorf("config %s of container %s: fromParam %q %s", k.FileName, container.Name, k.FromParam, err.Error())
+			}
+			items := []corev1.KeyToPath{
+				{
+					Key:  k.FileName,
+					Path: "path/to/" + k.FileName,
+				}}
+			volume := corev1.Volume{Name: configVolumeName(component, k.FileName)}
+			switch s.source {
+			case ConfigSensitive:
+				volume.Secret = &corev1.SecretVolumeSource{SecretName: configSecretName(component, app), Items: items}
+			case ConfigSecretKey:
+				items[0].Key = s.key
+				volume.Secret = &corev1.SecretVolumeSource{SecretName: s.name, Items: items}
+			default:
+				volume.ConfigMap = &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						Name: app.Name + "-" + component.Name + "-" + component.Version + "-" + "configmap"},
+					Items: items,
+				}
+			}
+			volumes = append(volumes, volume)
+		}
+	}
+	return volumes, nil
+}
+
+// configChecksum checksum of the content of the configmap and the secret of component, empty when it has none.
+// Keys of existing secrets are not part of it, their changes reach the pods only when they restart.
+func configChecksum(component *v3.Component, app *v3.Application) string {
+	data := make(map[string][]byte)
+	for k, v := range NewConfigMapObject(component, app).Data {
+		data[ConfigMapKind.Kind+"/"+k] = []byte(v)
+	}
+	for k, v := range NewConfigSecretObject(component, app).Data {
+		data[SecretKind.Kind+"/"+k] = v
+	}
+	if len(data) == 0 {
+		return ""
+	}
+	return GetDataChecksum(data)
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestParseConfigSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		fromParam string
+		want      envSource
+		wantErr   bool
+	}{
+		{name: "configmap", fromParam: "", want: envSource{}},
+		{name: "sensitive", fromParam: "sensitive", want: envSource{source: ConfigSensitive}},
+		{name: "secret key", fromParam: "secretKeyRef:tls/tls.crt", want: envSource{source: ConfigSecretKey, name: "tls", key: "tls.crt"}},
+		{name: "secret key without key", fromParam: "secretKeyRef:tls", wantErr: true},
+		{name: "configmap key", fromParam: "configMapKeyRef:cm/key", wantErr: true},
+		{name: "field path", fromParam: "metadata.name", wantErr: true},
+		{name: "case matters", fromParam: "Sensitive", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigSource(v3.ConfigFile{FileName: "app.conf", Path: "/etc/app", FromParam: tt.fromParam})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigSource(%q) error = %v, wantErr %v", tt.fromParam, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseConfigSource(%q) = %+v, want %+v", tt.fromParam, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigMounted(t *testing.T) {
+	tests := []struct {
+		name string
+		file v3.ConfigFile
+		want bool
+	}{
+		{name: "inline", file: v3.ConfigFile{FileName: "a", Path: "/etc", Value: "x"}, want: true},
+		{name: "inline without value", file: v3.ConfigFile{FileName: "a", Path: "/etc"}, want: false},
+		{name: "sensitive without value", file: v3.ConfigFile{FileName: "a", Path: "/etc", FromParam: "sensitive"}, want: false},
+		{name: "secret key without value", file: v3.ConfigFile{FileName: "a", Path: "/etc", FromParam: "secretKeyRef:s/k"}, want: true},
+		{name: "no path", file: v3.ConfigFile{FileName: "a", Value: "x"}, want: false},
+		{name: "no file name", file: v3.ConfigFile{Path: "/etc", Value: "x"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := configMounted(tt.file); got != tt.want {
+				t.Errorf("configMounted(%+v) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func newTestSecret(data map[string]string) *corev1.Secret {
+	secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo-web-v1-secret"}, Data: map[string][]byte{}}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	return withAppliedSecret(secret)
+}
+
+// TestWithAppliedSecret last-applied has the key names only, a removed key is deleted by the next patch
+func TestWithAppliedSecret(t *testing.T) {
+	applied := newTestSecret(map[string]string{"db.conf": "password=secret", "old.conf": "token"})
+	annotation := applied.Annotations[LastAppliedConfigAnnotation]
+	for _, value := range []string{"password=secret", "token"} {
+		if strings.Contains(annotation, base64.StdEncoding.EncodeToString([]byte(value))) {
+			t.Fatalf("last-applied carries the value %q: %s", value, annotation)
+		}
+	}
+	recorded := corev1.Secret{}
+	if err := json.Unmarshal([]byte(annotation), &recorded); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorded.Data) != 2 || len(recorded.Data["db.conf"]) != 0 || len(recorded.Data["old.conf"]) != 0 {
+		t.Errorf("last-applied data = %v, want the two key names with empty values", recorded.Data)
+	}
+	if changed := newTestSecret(map[string]string{"db.conf": "password=new", "old.conf": "token"}); applied.Annotations[ChecksumAnnotation] == changed.Annotations[ChecksumAnnotation] {
+		t.Errorf("%s did not change with the data", ChecksumAnnotation)
+	}
+
+	live := applied.DeepCopy()
+	live.ResourceVersion = "7"
+	target := newApplyTarget(nil, nil, corev1.Secret{})
+	tests := []struct {
+		name    string
+		desired map[string]string
+		// data of the patch, nil when nothing changes
+		want map[string]interface{}
+	}{
+		{name: "unchanged", desired: map[string]string{"db.conf": "password=secret", "old.conf": "token"}},
+		{name: "key removed", desired: map[string]string{"db.conf": "password=secret"}, want: map[string]interface{}{"old.conf": nil}},
+		{name: "value changed", desired: map[string]string{"db.conf": "password=new", "old.conf": "token"},
+			want: map[string]interface{}{"db.conf": "cGFzc3dvcmQ9bmV3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := target.patch(newTestSecret(tt.desired), live)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == nil {
+				if patch != nil {
+					t.Errorf("patch = %s, want none", patch)
+				}
+				return
+			}
+			patchMap := struct {
+				Data map[string]interface{} `json:"data"`
+			}{}
+			if err := json.Unmarshal(patch, &patchMap); err != nil {
+				t.Fatal(err)
+			}
+			if len(patchMap.Data) != len(tt.want) {
+				t.Fatalf("patch data = %v, want %v", patchMap.Data, tt.want)
+			}
+			for k, v := range tt.want {
+				if got, ok := patchMap.Data[k]; !ok || got != v {
+					t.Errorf("patch data %s = %v, want %v", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func newChecksumComponent(files ...v3.ConfigFile) *v3.Component {
+	return &v3.Component{
+		Name:       "web",
+		Version:    "v1",
+		Containers: []v3.ComponentContainer{{Name: "web", Image: "nginx", Config: files}},
+	}
+}
+
+func TestConfigChecksum(t *testing.T) {
+	app := &v3.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo"}}
+	inline := v3.ConfigFile{FileName: "app.conf", Path: "/etc/app", Value: "a=1"}
+	sensitive := v3.ConfigFile{FileName: "db.conf", Path: "/etc/app", Value: "password=1", FromParam: ConfigSensitive}
+	existing := v3.ConfigFile{FileName: "tls.crt", Path: "/etc/tls", FromParam: "secretKeyRef:tls/tls.crt"}
+	base := configChecksum(newChecksumComponent(inline, sensitive), app)
+	if base == "" {
+		t.Fatal("configChecksum of a component with config files is empty")
+	}
+
+	changed := func(file v3.ConfigFile, value string) v3.ConfigFile {
+		file.Value = value
+		return file
+	}
+	moved := inline
+	moved.FromParam = ConfigSensitive
+	// want is empty, unchanged (same as base) or changed
+	tests := []struct {
+		name      string
+		component *v3.Component
+		want      string
+	}{
+		{name: "no config", component: newChecksumComponent(), want: ""},
+		{name: "existing secret only", component: newChecksumComponent(existing), want: ""},
+		{name: "unchanged", component: newChecksumComponent(inline, sensitive), want: "unchanged"},
+		{name: "order", component: newChecksumComponent(sensitive, inline), want: "unchanged"},
+		{name: "existing secret added", component: newChecksumComponent(inline, sensitive, existing), want: "unchanged"},
+		{name: "path changed", component: newChecksumComponent(v3.ConfigFile{FileName: "app.conf", Path: "/etc/other", Value: "a=1"}, sensitive), want: "unchanged"},
+		{name: "inline changed", component: newChecksumComponent(changed(inline, "a=2"), sensitive), want: "changed"},
+		{name: "sensitive changed", component: newChecksumComponent(inline, changed(sensitive, "password=2")), want: "changed"},
+		{name: "file removed", component: newChecksumComponent(inline), want: "changed"},
+		{name: "moved to the secret", component: newChecksumComponent(moved, sensitive), want: "changed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := configChecksum(tt.component, app)
+			switch tt.want {
+			case "":
+				if got != "" {
+					t.Errorf("configChecksum = %q, want empty", got)
+				}
+			case "unchanged":
+				if got != base {
+					t.Errorf("configChecksum = %q, want the unchanged %q", got, base)
+				}
+			case "changed":
+				if got == base || got == "" {
+					t.Errorf("configChecksum = %q, want it to differ from %q", got, base)
+				}
+			}
+		})
+	}
+}
+
+// TestConfigChecksumAnnotation the checksum is on the pod template, custom metric annotations do not replace it
+func TestConfigChecksumAnnotation(t *testing.T) {
+	app := &v3.Application{ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo"}}
+	component := newChecksumComponent(v3.ConfigFile{FileName: "app.conf", Path: "/etc/app", Value: "a=1"})
+	component.ComponentTraits.CustomMetric = &v3.CustomMetric{Enable: true, Uri: "/metrics"}
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := deploy.Spec.Template.Annotations[ConfigChecksumAnnotation], configChecksum(component, app); got != want {
+		t.Errorf("pod template %s = %q, want %q", ConfigChecksumAnnotation, got, want)
+	}
+	if _, ok := deploy.Annotations[ConfigChecksumAnnotation]; ok {
+		t.Errorf("%s is set on the deployment, want the pod template only", ConfigChecksumAnnotation)
+	}
+}
@@ -5,24 +5,28 @@ import (
 	"strings"
 
 	log "github.com/sirupsen/logrus"
-	"k8s.io/apimachinery/pkg/labels"
 
-	//typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"github.com/hd-Li/types/apis/apps/v1beta2"
 	"github.com/hd-Li/types/apis/autoscaling/v2beta2"
+	batchv1 "github.com/hd-Li/types/apis/batch/v1"
+	batchv1beta1 "github.com/hd-Li/types/apis/batch/v1beta1"
 	v1 "github.com/hd-Li/types/apis/core/v1"
 	"github.com/hd-Li/types/config"
+	"github.com/rancher/norman/types"
 	"k8s.io/apimachinery/pkg/runtime"
+	k8stypes "k8s.io/apimachinery/pkg/types"
 
-	//utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
 	//appsv1beta2 "k8s.io/api/apps/v1beta2"
 	"k8s.io/apimachinery/pkg/api/errors"
-	//"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/kubernetes/scheme"
 	istioauthnv1alpha1 "github.com/hd-Li/types/apis/authentication.istio.io/v1alpha1"
 	istioconfigv1alpha2 "github.com/hd-Li/types/apis/config.istio.io/v1alpha2"
 	istionetworkingv1alph3 "github.com/hd-Li/types/apis/networking.istio.io/v1alpha3"
 	istiorbacv1alpha1 "github.com/hd-Li/types/apis/rbac.istio.io/v1alpha1"
+	batchv1api "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
@@ -32,6 +36,11 @@ import (
 const (
 	// LastAppliedConfigAnnotation define LastAppliedConfigAnnotation
 	LastAppliedConfigAnnotation string = "application/last-applied-configuration"
+	// ChecksumAnnotation define ChecksumAnnotation, checksum of the secret data left out of LastAppliedConfigAnnotation
+	ChecksumAnnotation string = "application/checksum"
+	// ConfigChecksumAnnotation define ConfigChecksumAnnotation, checksum of the config files on the pod template of a component version,
+	// a change of the config rolls its pods
+	ConfigChecksumAnnotation string = "application/config-checksum"
 )
 
 type controller struct {
@@ -48,6 +57,14 @@ type controller struct {
 	autoscaleClient          v2beta2.HorizontalPodAutoscalerInterface //zk
 	deploymentLister         v1beta2.DeploymentLister
 	deploymentClient         v1beta2.DeploymentInterface
+	statefulSetLister        v1beta2.StatefulSetLister
+	statefulSetClient        v1beta2.StatefulSetInterface
+	daemonSetLister          v1beta2.DaemonSetLister
+	daemonSetClient          v1beta2.DaemonSetInterface
+	jobLister                batchv1.JobLister
+	jobClient                batchv1.JobInterface
+	cronJobLister            batchv1beta1.CronJobLister
+	cronJobClient            batchv1beta1.CronJobInterface
 	serviceLister            v1.ServiceLister
 	serviceClient            v1.ServiceInterface
 	virtualServiceLister     istionetworkingv1alph3.VirtualServiceLister
@@ -56,6 +73,8 @@ type controller struct {
 	destClient               istionetworkingv1alph3.DestinationRuleInterface
 	configmapLister          v1.ConfigMapLister    //zk
 	configmapClient          v1.ConfigMapInterface //zk
+	claimLister              v1.PersistentVolumeClaimLister
+	claimClient              v1.PersistentVolumeClaimInterface
 	gatewayLister            istionetworkingv1alph3.GatewayLister
 	gatewayClient            istionetworkingv1alph3.GatewayInterface
 	policyLister             istioauthnv1alpha1.PolicyLister
@@ -77,18 +96,20 @@ type controller struct {
 	quotaspecbindingLister   istioconfigv1alpha2.QuotaSpecBindingLister
 	quotaspecbindingClient   istioconfigv1alpha2.QuotaSpecBindingInterface
 	recorder                 record.EventRecorder
+	statuses                 statusCache
+	observed                 observedCache
+	requests                 statusRequests
+	appliers                 map[string]*applyTarget
 }
 
 // Register all resource
 func Register(ctx context.Context, userContext *config.UserOnlyContext) {
-	/*
-		utilruntime.Must(v3.AddToScheme(scheme.Scheme))
-		log.Infoln("Creating event broadcaster")
-		eventBroadcaster := record.NewBroadcaster()
-		//eventBroadcaster.StartLogging(fmt.Printf)
-		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: userContext.Core.Events("")})
-		recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "application-controllere"})
-	*/
+	utilruntime.Must(v3.AddToScheme(scheme.Scheme))
+	log.Infoln("Creating event broadcaster")
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(log.Debugf)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: userContext.K8sClient.CoreV1().Events("")})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "application-controller"})
 	c := controller{
 		applicationClient:        userContext.Project.Applications(""),
 		applicationLister:        userContext.Project.Applications("").Controller().Lister(),
@@ -97,7 +118,17 @@ func Register(ctx context.Context, userContext *config.UserOnlyContext) {
 		appsV1beta2:              userContext.Apps,
 		deploymentLister:         userContext.Apps.Deployments("").Controller().Lister(),
 		deploymentClient:         userContext.Apps.Deployments(""),
+		statefulSetLister:        userContext.Apps.StatefulSets("").Controller().Lister(),
+		statefulSetClient:        userContext.Apps.StatefulSets(""),
+		daemonSetLister:          userContext.Apps.DaemonSets("").Controller().Lister(),
+		daemonSetClient:          userContext.Apps.DaemonSets(""),
+		jobLister:                userContext.BatchV1.Jobs("").Controller().Lister(),
+		jobClient:                userContext.BatchV1.Jobs(""),
+		cronJobLister:            userContext.BatchV1Beta1.CronJobs("").Controller().Lister(),
+		cronJobClient:            userContext.BatchV1Beta1.CronJobs(""),
 		configmapLister:          userContext.Core.ConfigMaps("").Controller().Lister(), //zk
+		claimLister:              userContext.Core.PersistentVolumeClaims("").Controller().Lister(),
+		claimClient:              userContext.Core.PersistentVolumeClaims(""),
 		configmapClient:          userContext.Core.ConfigMaps(""),                       //zk
 		podLister:                userContext.Core.Pods("").Controller().Lister(),       //zk
 		podClient:                userContext.Core.Pods(""),                             //zk
@@ -131,18 +162,62 @@ func Register(ctx context.Context, userContext *config.UserOnlyContext) {
 		quotaspecClient:          userContext.IstioConfig.QuotaSpecs(""),
 		quotaspecbindingLister:   userContext.IstioConfig.QuotaSpecBindings("").Controller().Lister(),
 		quotaspecbindingClient:   userContext.IstioConfig.QuotaSpecBindings(""),
+		recorder:                 recorder,
 	}
+	c.appliers = c.newApplyTargets()
 	// 添加处理Handler s.sync 所有资源的处理逻辑都包含在内
-	c.applicationClient.AddHandler(ctx, "applictionCreateOrUpdate", c.sync)
+	// panic 只影响当前 application, 以错误返回并带退避重试
+	c.applicationClient.AddHandler(ctx, "applictionCreateOrUpdate", c.recovered(c.sync))
+	// finalizer 清理 adapter-config 与 ClusterRbacConfig 等 OwnerReference 无法回收的内容
+	c.applicationClient.AddLifecycle(ctx, "application-teardown", &teardown{c: &c})
+	// owned 对象被修改或删除时重新同步所属 application
+	// workload 只有 status 变化时只重新计算 application status
+	c.watchOwned(c.enqueue, c.enqueueStatus)
+	// pod ready 或重启状态变化时重新计算 application status
+	c.watchPods(c.enqueueStatus)
+	// env 与 config 引用的 configmap secret 创建或删除时重新同步引用它的 application
+	c.watchReferences(c.enqueue)
+	// sidecar registry 变化时重新同步所有 application
+	c.watchSidecars(c.enqueue)
+}
+
+// enqueue sync the application through the whole pipeline
+func (c *controller) enqueue(namespace, name string) {
+	c.requests.request(namespace+"/"+name, false)
+	c.applicationClient.Controller().Enqueue(namespace, name)
+}
+
+// enqueueStatus only recompute the status of the application, unless a full sync is pending
+func (c *controller) enqueueStatus(namespace, name string) {
+	c.requests.request(namespace+"/"+name, true)
+	c.applicationClient.Controller().Enqueue(namespace, name)
 }
 
 func (c *controller) sync(key string, app *v3.Application) (runtime.Object, error) {
 	//log.SetFlags(log.LstdFlags | log.Lshortfile)
+	statusOnly := c.requests.take(key)
 	if app == nil {
+		c.statuses.delete(key)
+		c.observed.delete(key)
+		return nil, nil
+	}
+	// 删除中的 application 由 teardown 处理
+	if app.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	// status 写入或重复事件不会改变 generation, owned 对象也未变化时无需重新渲染
+	if c.observed.unchanged(key, app.Generation, c.ownedFingerprint(app)) {
+		log.Debugf("Application %s generation %d and owned objects not changed, skip", key, app.Generation)
 		return nil, nil
 	}
+	// 上次同步成功且 spec 未变化时, owned workload 的 status 变化不需要重新 apply
+	if statusOnly && c.observed.synced(key, app.Generation) {
+		return nil, c.syncStatusOnly(key, app)
+	}
 	//log.Infof("application info %v", application)
-	//app := application.DeepCopy()
+	// 未经过 defaulting webhook 的 application 在此补齐默认值, 不修改缓存中的对象
+	app = app.DeepCopy()
+	SetDefaults(app)
 
 	//c.syncNamespaceCommon(app)
 
@@ -159,38 +234,57 @@ func (c *controller) sync(key string, app *v3.Application) (runtime.Object, erro
 		oldcomresource = app.DeepCopy().Status.ComponentResource
 	}
 	app.Status.ComponentResource = make(map[string]v3.ComponentResources)
-	/*if reflect.DeepEqual(app.Spec.OptTraits.ImagePullConfig, v3.ImagePullConfig{}) {
-		log.Debugf("Component %s's image pull configuration is not configured,ignore it")
-	} else {
-		if app.Spec.OptTraits.ImagePullConfig.Username == "" || app.Spec.OptTraits.ImagePullConfig.Registry == "" || app.Spec.OptTraits.ImagePullConfig.Password == "" {
-			log.Errorf("Component %s's imagepullconfig need review", app.Name)
-		} else {
-			_, _ = c.syncImagePullSecrets(app)
-		}
-	}*/ //Not needed for the time being
+	// 所有 pod 共用 application 的镜像拉取秘钥
+	pullSecretErr := c.syncImagePullSecret(app)
 	var deletelist []string
+	status := newStatusBuilder()
+	generation := app.Generation
 	for _, component := range components {
 		//if containers is nil, the app is trusted, this controller does not manage its workload's lifecycle
 		if len(component.Containers) == 0 {
 			trusted = true
 		}
+		key := app.Name + "_" + component.Name + "_" + component.Version
 		ownerRefOfDeploy := new(metav1.OwnerReference)
 		if trusted == false {
-			delete(oldcomresource, (app.Name + "_" + component.Name + "_" + component.Version))
-			c.syncConfigmaps(&component, app)
-			err := c.syncWorkload(&component, app, ownerRefOfDeploy)
-			if err != nil {
-				return nil, err
+			delete(oldcomresource, key)
+			status.component(key, pullSecretErr)
+			status.component(key, c.syncConfigmaps(&component, app))
+			claimsErr := c.syncClaims(&component, app)
+			status.component(key, claimsErr)
+			if unsupported := unsupportedEnvSources(&component); len(componentReferences(&component)) != 0 || len(unsupported) != 0 {
+				missing, err := c.missingReferences(&component, app)
+				if err != nil {
+					status.component(key, err)
+				} else {
+					if len(missing) != 0 {
+						log.Warnf("Env references %v of %s not found", missing, app.Namespace+":"+app.Name+":"+component.Name)
+					}
+					status.references(key, missing, unsupported)
+				}
 			}
-		} else {
-			err := c.syncTrustedWorkload(&component, app, ownerRefOfDeploy)
-			if err != nil {
-				return nil, err
+			// without its claims the pods would stay Pending, the workload is left as it is
+			if claimsErr != nil {
+				app.Status.ComponentResource[key] = v3.ComponentResources{
+					Workload: app.Name + "-" + component.Name + "-" + "workload" + "-" + component.Version,
+				}
+			} else if err := c.syncWorkload(&component, app, ownerRefOfDeploy); err != nil {
+				//keep the version in status, otherwise gc would treat it as removed
+				app.Status.ComponentResource[key] = v3.ComponentResources{
+					Workload: app.Name + "-" + component.Name + "-" + "workload" + "-" + component.Version,
+				}
+				status.component(key, err)
 			}
+		} else {
+			status.component(key, c.syncTrustedWorkload(&component, app, ownerRefOfDeploy))
 		}
 		//log.Infof("ownerRefOfDeploy INFO IS %v", ownerRefOfDeploy)
-		if ownerRefOfDeploy.APIVersion != "" {
-			c.syncHpa(&component, app, ownerRefOfDeploy)
+		// daemonsets and jobs have no replicas to scale
+		if ownerRefOfDeploy.APIVersion != "" && scalable(component.WorkloadType) {
+			err := c.syncHpa(&component, app, ownerRefOfDeploy)
+			if component.ComponentTraits.Autoscaling != nil {
+				status.autoscale(key, err)
+			}
 		}
 	}
 	if app.Spec.OptTraits.Fusing != nil {
@@ -203,26 +297,85 @@ func (c *controller) sync(key string, app *v3.Application) (runtime.Object, erro
 			for _, i := range app.Spec.OptTraits.Fusing.PodList {
 				c.syncFusing(i, app.Namespace, action)
 			}
-			app.Spec.OptTraits.Fusing = nil
+			// fusing is a one-shot action, clear it from the stored spec, app carries the defaults of the controller
+			err := c.clearFusing(app)
+			if err != nil {
+				log.Errorf("Clear fusing of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+				status.traffic(err)
+			}
 		}
 	}
-	c.syncService(app)
-	c.syncAuthor(app)
-	c.syncPolicy(app)
+	if servesTraffic(app) {
+		status.traffic(c.syncService(app))
+		status.traffic(c.syncAuthor(app))
+		status.traffic(c.syncPolicy(app))
+	} else {
+		status.traffic(c.removeTraffic(app))
+	}
 	log.Debugf("These versions need to be removed %v", oldcomresource)
 	for k := range oldcomresource {
 		deletelist = append(deletelist, k)
 	}
 	if len(deletelist) != 0 {
-		errlist := c.gc(app.Namespace, deletelist)
+		errlist := c.gc(app, deletelist, oldcomresource)
 		if len(errlist) != 0 {
 			for _, i := range errlist {
 				app.Status.ComponentResource[i] = v3.ComponentResources{}
 			}
 		}
 	}
-	c.syncStatus(app)
-	return nil, nil
+	statusErr := c.syncStatus(app, status)
+	//failed steps are returned together, the handler requeue the key with per-key exponential backoff
+	err := types.NewErrors(status.err(), statusErr)
+	if err == nil {
+		c.observed.set(key, generation, c.ownedFingerprint(app))
+	} else {
+		// a failed step is retried by the full pipeline, never by a status only sync
+		c.observed.delete(key)
+	}
+	return nil, err
+}
+
+// clearFusing remove optTraits.fusing from the stored application, nothing else of the spec is written
+func (c *controller) clearFusing(app *v3.Application) error {
+	_, err := c.applicationClient.ObjectClient().Patch(app.Name, app, k8stypes.MergePatchType, []byte(`{"spec":{"optTraits":{"fusing":null}}}`))
+	return err
+}
+
+// syncStatusOnly recompute the status of app from its workloads and pods without applying anything.
+// Every step succeeded at this generation, only the references are looked up again.
+func (c *controller) syncStatusOnly(key string, app *v3.Application) error {
+	log.Debugf("Recompute status of application %s", key)
+	app = app.DeepCopy()
+	SetDefaults(app)
+	status := newStatusBuilder()
+	var trusted bool = false
+	for i := range app.Spec.Components {
+		component := &app.Spec.Components[i]
+		if len(component.Containers) == 0 {
+			trusted = true
+		}
+		versionKey := app.Name + "_" + component.Name + "_" + component.Version
+		if trusted == false {
+			if unsupported := unsupportedEnvSources(component); len(componentReferences(component)) != 0 || len(unsupported) != 0 {
+				missing, err := c.missingReferences(component, app)
+				if err != nil {
+					return err
+				}
+				status.references(versionKey, missing, unsupported)
+			}
+		}
+		if scalable(component.WorkloadType) && component.ComponentTraits.Autoscaling != nil {
+			status.autoscale(versionKey, nil)
+		}
+	}
+	err := c.syncStatus(app, status)
+	if err != nil {
+		c.observed.delete(key)
+		return err
+	}
+	c.observed.set(key, app.Generation, c.ownedFingerprint(app))
+	return nil
 }
 
 func (c *controller) syncNamespaceCommon(app *v3.Application) error {
@@ -246,6 +399,7 @@ func (c *controller) syncNamespaceCommon(app *v3.Application) error {
 		if errors.IsNotFound(err) {
 			gateway := NewGatewayObject(app, ns)
 			_, err = c.gatewayClient.Create(&gateway)
+			c.recordEvent(app, ActionCreate, "Gateway", gateway.Name, err)
 			if err != nil {
 				log.Infof("Create gateway error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
 			}
@@ -259,6 +413,7 @@ func (c *controller) syncNamespaceCommon(app *v3.Application) error {
 		if errors.IsNotFound(err) {
 			policy := NewPolicyObject(app, ns)
 			_, err = c.policyClient.Create(&policy)
+			c.recordEvent(app, ActionCreate, "Policy", policy.Name, err)
 			if err != nil {
 				log.Infof("Create policy error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
 			}
@@ -272,6 +427,7 @@ func (c *controller) syncNamespaceCommon(app *v3.Application) error {
 		if errors.IsNotFound(err) {
 			clusterConfig := NewClusterRbacConfig(app, ns)
 			_, err = c.clusterconfigClient.Create(&clusterConfig)
+			c.recordEvent(app, ActionCreate, "ClusterRbacConfig", clusterConfig.Name, err)
 			if err != nil {
 				log.Errorf("Create clusterrbacconfig error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
 			}
@@ -284,6 +440,7 @@ func (c *controller) syncNamespaceCommon(app *v3.Application) error {
 				clusterrbacconfig.ObjectMeta.Labels[app.Namespace] = "included"
 				clusterrbacconfig.Namespace = "default" //avoid the client-go bug
 				_, err = c.clusterconfigClient.Update(clusterrbacconfig)
+				c.recordEvent(app, ActionUpdate, "ClusterRbacConfig", clusterrbacconfig.Name, err)
 				if err != nil {
 					log.Errorf("Update clusterrbacconfig error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
 				}
@@ -297,469 +454,273 @@ func (c *controller) syncNamespaceCommon(app *v3.Application) error {
 
 func (c *controller) syncConfigmaps(component *v3.Component, app *v3.Application) error {
 	log.Infof("Sync configmap for %s", app.Namespace+":"+component.Name+":"+component.Version)
-	object := NewConfigMapObject(component, app)
-	if len(object.Data) == 0 {
+	if err := c.syncConfigSecret(component, app); err != nil {
+		log.Errorf("Sync config secret for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name + ":" + component.Version), err.Error())
+		return err
+	}
+	object := renderConfigMap(component, app)
+	if object == nil {
 		log.Debugf("ConfigMap data is nil, Do not need sync configmap for %s", app.Namespace+":"+app.Name+":"+component.Name+":"+component.Version)
 		return nil
 	}
-	appliedString := GetObjectApplied(object)
-	configmapname := app.Name + "-" + component.Name + "-" + component.Version + "-" + "configmap"
-	configmap, err := c.configmapLister.Get(app.Namespace, configmapname)
-	object.Annotations = make(map[string]string)
-	object.Annotations[LastAppliedConfigAnnotation] = appliedString
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.configmapClient.Create(&object)
-			if err != nil {
-				log.Errorf("Create configmap for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name + ":" + component.Version), err.Error())
-			}
-		} else {
-			log.Errorf("Get configmap for %s failed", configmapname)
-		}
-	} else {
-		if configmap != nil {
-			if configmap.Annotations[LastAppliedConfigAnnotation] != appliedString {
-				_, err := c.configmapClient.Update(&object)
-				if err != nil {
-					log.Errorf("Update configmap for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
-					return nil
-				}
-				var labelmap map[string]string = make(map[string]string)
-				labelmap["app"] = app.Name + "-" + "workload"
-				pods, err := c.podLister.List(app.Namespace, labels.SelectorFromSet(labelmap))
-				if err != nil {
-					log.Errorf("Get %s pods failed", app.Namespace)
-				}
-				for _, i := range pods {
-					log.Infof("Namespace %s pod name %s", i.Namespace, i.Name)
-					deletePolicy := metav1.DeletePropagationBackground
-					err = c.podClient.DeleteNamespaced(i.Namespace, i.Name, &metav1.DeleteOptions{
-						PropagationPolicy: &deletePolicy,
-					})
-					if err != nil {
-						log.Errorf("Delete %s pod %s failed", i.Namespace, i.Name)
-					}
-				}
-			}
-		}
+	// the pods are rolled by the workload, ConfigChecksumAnnotation of its pod template changes with the data
+	if _, _, err := c.apply(app, object); err != nil {
+		log.Errorf("Sync configmap for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name + ":" + component.Version), err.Error())
+		return err
 	}
 	return nil
 }
 
-func (c *controller) syncImagePullSecrets(app *v3.Application) (string, error) {
-	log.Infof("Sync imagepull secret for %s", app.Namespace)
-	object := NewSecretObject(app)
-	if len(object.Data) == 0 {
-		log.Debugf("ImagePullSecret not define,ignore %s", app.Namespace+":"+app.Name)
-		return "", nil
+// syncConfigSecret apply the secret of the sensitive config files of component, it is deleted when there are none left
+func (c *controller) syncConfigSecret(component *v3.Component, app *v3.Application) error {
+	secret := renderConfigSecret(component, app)
+	if secret == nil {
+		return c.remove(app, SecretKind.Kind, configSecretName(component, app))
 	}
-	appliedString := GetObjectApplied(object)
+	_, _, err := c.apply(app, secret)
+	return err
+}
 
-	secretname := app.Name + "-" + "registry-secret"
-	secret, err := c.secretLister.Get(app.Namespace, secretname)
-	object.Annotations = make(map[string]string)
-	object.Annotations[LastAppliedConfigAnnotation] = appliedString
+// syncImagePullSecret apply the registry secret of optTraits.imagePullConfig, it is deleted when the config is removed
+// and with the application through its OwnerReference
+func (c *controller) syncImagePullSecret(app *v3.Application) error {
+	secret, err := renderImagePullSecret(app)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.secretClient.Create(&object)
-			if err != nil {
-				log.Errorf("Create secret for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				return "", err
-			}
-			log.Infof("Create Secret %s successful", secretname)
-			return secretname, nil
-
-		}
-		log.Errorf("Get sercret for %s failed", secretname)
-		return "", err
+		log.Errorf("Render imagepull secret for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+		return err
 	}
-	if secret != nil {
-		if secret.Annotations[LastAppliedConfigAnnotation] != appliedString {
-			_, err := c.secretClient.Update(&object)
-			if err != nil {
-				log.Errorf("Update secret for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				return "", err
-			}
-			return secretname, nil
-		}
+	if secret == nil {
+		return c.remove(app, SecretKind.Kind, imagePullSecretName(app))
 	}
-	return secretname, nil
+	_, _, err = c.apply(app, secret)
+	return err
 }
 
-func (c *controller) syncWorkload(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) error {
-	resourceWorkloadType := "deployment"
-	if resourceWorkloadType == "deployment" {
-		err := c.syncDeployment(component, app, ref)
+// syncClaims apply the claims mounted by component, they are kept when the version is removed
+func (c *controller) syncClaims(component *v3.Component, app *v3.Application) error {
+	claims, err := renderClaims(component, app)
+	if err != nil {
+		log.Errorf("Render claims for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+		return err
+	}
+	var errs []error
+	for _, claim := range claims {
+		_, _, err := c.apply(app, claim)
 		if err != nil {
-			return err
+			errs = append(errs, err)
 		}
 	}
-
-	return nil
+	return types.NewErrors(errs...)
 }
 
-//zk
-func (c *controller) syncStatus(app *v3.Application) {
-	_, err := c.applicationClient.Update(app)
+// syncWorkload apply the workload of component and the objects it owns, ref is set to the workload
+func (c *controller) syncWorkload(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) error {
+	log.Infof("Sync workload for %s", app.Namespace+":"+component.Name)
+	object, err := renderWorkload(component, app)
 	if err != nil {
-		log.Errorf("Update application for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+		log.Errorf("Render workload for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+		return err
+	}
+	var workload runtime.Object
+	if job, ok := object.(*batchv1api.Job); ok {
+		workload, err = c.applyJob(app, app.Name+"_"+component.Name+"_"+component.Version, job)
 	} else {
-		log.Infof("Update application for %s Done", (app.Namespace + ":" + app.Name))
+		workload, _, err = c.apply(app, object)
 	}
-}
-
-func (c *controller) syncDeployment(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) error {
-	log.Infof("Sync deploy for %s", app.Namespace+":"+component.Name)
-	object := NewDeployObject(component, app)
-	appliedString := GetObjectApplied(object)
-	//zk
-	object.Annotations = make(map[string]string)
-	object.Annotations[LastAppliedConfigAnnotation] = appliedString
-	deploy, err := c.deploymentLister.Get(app.Namespace, app.Name+"-"+component.Name+"-"+"workload"+"-"+component.Version)
 	if err != nil {
-		//log.Infof("Get deploy for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
-		if errors.IsNotFound(err) {
-			getdeploy, err := c.deploymentClient.Create(&object)
-			if err != nil {
-				log.Errorf("Create deploy for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
-				return err
-			}
-			*ref = *(metav1.NewControllerRef(getdeploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
-		}
-	} else {
-		if deploy != nil {
-			if deploy.Annotations[LastAppliedConfigAnnotation] != appliedString {
-				getdeploy, err := c.deploymentClient.Update(&object)
-				if err != nil {
-					log.Errorf("Update deploy for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
-					return err
-				}
-				*ref = *(metav1.NewControllerRef(getdeploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
-			} else {
-				*ref = *(metav1.NewControllerRef(deploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
-			}
+		log.Errorf("Sync workload for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+		return err
+	}
+	if workload == nil {
+		// finished job removed by its ttl
+		app.Status.ComponentResource[(app.Name + "_" + component.Name + "_" + component.Version)] = v3.ComponentResources{
+			Workload: object.(metav1.Object).GetName(),
 		}
+		return nil
 	}
-	log.Infof("Sync deploy for %s done!", app.Namespace+":"+app.Name+":"+component.Name)
+	*ref = *(metav1.NewControllerRef(workload.(metav1.Object), object.GetObjectKind().GroupVersionKind()))
 	app.Status.ComponentResource[(app.Name + "_" + component.Name + "_" + component.Version)] = v3.ComponentResources{
-		Workload: object.Name,
+		Workload: workload.(metav1.Object).GetName(),
 	}
-	return nil
-}
-
-func (c *controller) syncService(app *v3.Application) error {
-	log.Infof("Sync service for %s", app.Name)
-	object := NewServiceObject(app)
-	object.ObjectMeta.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(app, v3.SchemeGroupVersion.WithKind("Application"))}
-	objectString := GetObjectApplied(object)
-	//zk
-	object.Annotations = make(map[string]string)
-	object.Annotations[LastAppliedConfigAnnotation] = objectString
-
-	service, err := c.serviceLister.Get(app.Namespace, app.Name+"-"+"service")
-	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.serviceClient.Create(&object)
-			if err != nil {
-				log.Errorf("Create service for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
-			}
-		}
-	} else {
-		if service != nil {
-			if service.Annotations[LastAppliedConfigAnnotation] != objectString {
-				//c.serviceClient.DeleteNamespaced(service.Namespace, service.Name, &metav1.DeleteOptions{})
-				_, err = c.serviceClient.Update(&object)
-				if err != nil {
-					log.Errorf("Update(Create) Service for %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				}
-			}
-		}
-
-		_, err = c.serviceRoleLister.Get(app.Namespace, app.Name+"-"+"servicerole")
+	var errs []error
+	errs = append(errs, c.deleteReplacedWorkloads(app, ref.Name, ref.Kind))
+	for _, owned := range renderWorkloadOwned(component, app, ref) {
+		_, _, err := c.apply(app, owned)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				svcRoleObject := NewServiceRoleObject(app)
-				_, err = c.serviceRoleClient.Create(&svcRoleObject)
-				if err != nil {
-					log.Errorf("Create ServiceRole for %s Error : %s", (app.Name), err.Error())
-				}
-			}
+			errs = append(errs, err)
 		}
 	}
-	vsObject := NewVirtualServiceObject(app)
-	vsObjectString := GetObjectApplied(vsObject)
-	vsObject.Annotations[LastAppliedConfigAnnotation] = vsObjectString
-
-	vs, err := c.virtualServiceLister.Get(app.Namespace, (app.Name + "-" + "vs"))
+	err = types.NewErrors(errs...)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.virtualServiceClient.Create(&vsObject)
-			if err != nil {
-				log.Errorf("Create VirtualService error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-			}
-		}
-	} else {
-		if vs != nil {
-			if vs.Annotations[LastAppliedConfigAnnotation] != vsObjectString {
-				vsObject.ObjectMeta.ResourceVersion = vs.ObjectMeta.ResourceVersion
-				_, err = c.virtualServiceClient.Update(&vsObject)
-				if err != nil {
-					log.Errorf("Update VirtualService error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				}
-			}
-		}
+		log.Errorf("Sync workload for %s Error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+		return err
 	}
-	//if !(reflect.DeepEqual(app.Spec.OptTraits.LoadBalancer, v3.LoadBalancerSettings{})) || !(reflect.DeepEqual(app.Spec.OptTraits.CircuitBreaking, v3.CircuitBreaking{})) {
-	//if app.Spec.OptTraits.LoadBalancer != nil || app.Spec.OptTraits.CircuitBreaking != nil || len(app.Spec.OptTraits.GrayRelease) >= 2 {
-	destObject := NewDestinationruleObject(app)
-	destObjectString := GetObjectApplied(destObject)
-	log.Debugf("Destinationrule %s", destObjectString)
-	destObject.Annotations[LastAppliedConfigAnnotation] = destObjectString
+	log.Infof("Sync workload for %s done!", app.Namespace+":"+app.Name+":"+component.Name)
+	return nil
+}
 
-	dest, err := c.destLister.Get(app.Namespace, (app.Name + "-" + "destinationrule"))
+//zk
+func (c *controller) syncStatus(app *v3.Application, b *statusBuilder) error {
+	old, err := c.getStatus(app)
 	if err != nil {
-		//log.Errorf("Get DestinationRule error for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.destClient.Create(&destObject)
-			if err != nil {
-				log.Errorf("Create DestinationRule error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-			}
-		}
-	} else {
+		log.Errorf("Get status of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+	}
+	status := c.computeStatus(app, old, b)
+	err = c.writeStatus(app, old, status)
+	if err != nil {
+		log.Errorf("Update status of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+	}
+	return err
+}
 
-		if dest != nil {
-			if dest.Annotations[LastAppliedConfigAnnotation] != destObjectString {
-				destObject.ObjectMeta.ResourceVersion = dest.ObjectMeta.ResourceVersion
-				_, err := c.destClient.Update(&destObject)
-				if err != nil {
-					log.Errorf("Update DestinationRule error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				}
-			}
+func (c *controller) syncService(app *v3.Application) error {
+	log.Infof("Sync service for %s", app.Name)
+	var errs []error
+	for _, object := range []runtime.Object{renderService(app), renderServiceRole(app), renderVirtualService(app), renderDestinationRule(app)} {
+		_, _, err := c.apply(app, object)
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
-	//}
-
-	return nil
+	return types.NewErrors(errs...)
 }
-func (c *controller) syncAuthor(app *v3.Application) error {
-	object := NewServiceRoleBinding(app)
-	objectString := GetObjectApplied(object)
-	object.Annotations = make(map[string]string)
-	object.Annotations[LastAppliedConfigAnnotation] = objectString
 
-	serviceRoleBinding, err := c.serviceRoleBindingLister.Get(app.Namespace, object.Name)
+func (c *controller) syncAuthor(app *v3.Application) error {
+	object := renderServiceRoleBinding(app)
+	if object != nil {
+		_, _, err := c.apply(app, object)
+		return err
+	}
+	name := app.Name + "-" + "servicerolebinding"
+	_, err := c.serviceRoleBindingLister.Get(app.Namespace, name)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			if app.Spec.OptTraits.WhiteList != nil {
-				if len(app.Spec.OptTraits.WhiteList.Users) == 0 {
-					log.Infoln("whitelist.user is nil,there is nothing to do")
-					return nil
-				}
-				_, err = c.serviceRoleBindingClient.Create(&object)
-				if err != nil {
-					log.Errorf("Create servicerolebinding error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				}
-			}
-		}
-	} else {
-		if serviceRoleBinding != nil {
-			if serviceRoleBinding.Annotations[LastAppliedConfigAnnotation] != objectString {
-				if app.Spec.OptTraits.WhiteList != nil {
-					if len(app.Spec.OptTraits.WhiteList.Users) == 0 {
-						log.Infof("whitelist is null ,need delete servicerolebinding and servicerole for %s", app.Name)
-						err = c.serviceRoleBindingClient.DeleteNamespaced(app.Namespace, app.Name+"-"+"servicerolebinding", &metav1.DeleteOptions{})
-						if err != nil {
-							log.Errorln(err)
-						}
-						err = c.serviceRoleClient.DeleteNamespaced(app.Namespace, app.Name+"-"+"servicerole", &metav1.DeleteOptions{})
-						if err != nil {
-							log.Errorln(err)
-						}
-						return nil
-					}
-					object.ObjectMeta.ResourceVersion = serviceRoleBinding.ObjectMeta.ResourceVersion
-					_, err = c.serviceRoleBindingClient.Update(&object)
-					if err != nil {
-						log.Errorf("Update servicerolebinding error for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-					}
-				} else {
-					log.Infof("whitelist is null ,need delete servicerolebinding and servicerole for %s", app.Name)
-					err = c.serviceRoleBindingClient.DeleteNamespaced(app.Namespace, app.Name+"-"+"servicerolebinding", &metav1.DeleteOptions{})
-					if err != nil {
-						log.Errorln(err)
-					}
-					err = c.serviceRoleClient.DeleteNamespaced(app.Namespace, app.Name+"-"+"servicerole", &metav1.DeleteOptions{})
-					if err != nil {
-						log.Errorln(err)
-					}
-					return nil
-				}
-			}
+			return nil
 		}
+		return err
+	}
+	log.Infof("whitelist is null ,need delete servicerolebinding for %s", app.Name)
+	err = c.serviceRoleBindingClient.DeleteNamespaced(app.Namespace, name, &metav1.DeleteOptions{})
+	c.recordEvent(app, ActionDelete, "ServiceRoleBinding", name, err)
+	if err != nil && !errors.IsNotFound(err) {
+		log.Errorln(err)
+		return err
 	}
 	return nil
 }
 
 func (c *controller) syncPolicy(app *v3.Application) error {
 	if app.Spec.OptTraits.RateLimit != nil {
-		c.syncQuotaPolicy(app)
+		return c.syncQuotaPolicy(app)
 	}
 	return nil
 }
 
 func (c *controller) syncQuotaPolicy(app *v3.Application) error {
 	log.Infof("Sync quotapolicy for %s", app.Namespace+":"+app.Name)
-
-	insObject := NewQuotaInstance(app)
-	//zk
-	insObjectString := GetObjectApplied(insObject)
-	insObject.Annotations = make(map[string]string)
-	insObject.Annotations[LastAppliedConfigAnnotation] = insObjectString
-
-	instance, err := c.instanceLister.Get(app.Namespace, app.Name+"-"+"quotainstance")
-	if err != nil {
-		//log.Infof("Get quotapolicy  for %s error : %s", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.instanceClient.Create(&insObject)
-			if err != nil {
-				log.Errorf("Create quotapolicy  for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				return nil
-			}
-		}
-	} else {
-
-		if instance != nil {
-			if instance.Annotations[LastAppliedConfigAnnotation] != insObjectString {
-				insObject.ObjectMeta.ResourceVersion = instance.ObjectMeta.ResourceVersion
-				_, err = c.instanceClient.Update(&insObject)
-				if err != nil {
-					log.Errorf("Update quotapolicy  for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				}
-			}
-		}
-	}
-	//config for client
-	specObject := NewQuotaSpec(app)
-	specObjectString := GetObjectApplied(specObject)
-	specObject.Annotations = make(map[string]string)
-	specObject.Annotations[LastAppliedConfigAnnotation] = specObjectString
-
-	_, err = c.quotaspecLister.Get(app.Namespace, app.Name+"-"+"quotaspec")
-	if err != nil {
-		//log.Infof("Get quotaspec  for %s error : %s", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.quotaspecClient.Create(&specObject)
-			if err != nil {
-				log.Errorf("Create quotaspec  for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				return nil
-			}
-		}
-	}
-
-	specbindingObject := NewQuotaSpecBinding(app)
-	specbindingObjectString := GetObjectApplied(specbindingObject)
-	specbindingObject.Annotations = make(map[string]string)
-	specbindingObject.Annotations[LastAppliedConfigAnnotation] = specbindingObjectString
-
-	_, err = c.quotaspecbindingLister.Get(app.Namespace, app.Name+"-"+"quotaspecbinding")
-	if err != nil {
-		//log.Errorf("Get quotaspecbinding for %s error : %s", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.quotaspecbindingClient.Create(&specbindingObject)
-			if err != nil {
-				log.Errorf("Create quotaspecbinding  for %s error : %s", (app.Namespace + ":" + app.Name), err.Error())
-				return nil
-			}
-		}
-	}
-
-	//config for (mixer) server
-	qhObject := NewQuotaHandlerObject(app)
-	qhObjectString := GetObjectApplied(qhObject)
-	qhObject.Annotations = make(map[string]string)
-	qhObject.Annotations[LastAppliedConfigAnnotation] = qhObjectString
-
-	quotahandler, err := c.handerLister.Get(app.Namespace, app.Name+"-"+"quotahandler")
-	if err != nil {
-		//log.Errorf("Get quotahandler for %s error : %s", app.Namespace+":"+app.Name+"-"+component.Name, err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.handlerClient.Create(qhObject)
-			if err != nil {
-				log.Errorf("Create quotahandler for %s error : %s", app.Namespace+":"+app.Name, err.Error())
-			}
-		}
-	} else {
-
-		if quotahandler != nil {
-			if quotahandler.Annotations[LastAppliedConfigAnnotation] != qhObjectString {
-				qhObject.ObjectMeta.ResourceVersion = quotahandler.ObjectMeta.ResourceVersion
-				_, err = c.handlerClient.Update(qhObject)
-				if err != nil {
-					log.Errorf("Update quotahandler for %s error : %s", app.Namespace+":"+app.Name, err.Error())
-				}
-			}
-		}
-	}
-
-	quotaruleObject := NewQuotaRuleObject(app)
-	quotaruleObjectString := GetObjectApplied(quotaruleObject)
-	quotaruleObject.Annotations = make(map[string]string)
-	quotaruleObject.Annotations[LastAppliedConfigAnnotation] = quotaruleObjectString
-	_, err = c.ruleLister.Get(app.Namespace, app.Name+"-"+"quotarule")
-	if err != nil {
-		//log.Errorf("Get quotarule for %s error : %s", app.Namespace+":"+app.Name+"-"+component.Name, err.Error())
-		if errors.IsNotFound(err) {
-			_, err = c.ruleClient.Create(&quotaruleObject)
-			if err != nil {
-				log.Errorf("Create quotarule for %s error : %s", app.Namespace+":"+app.Name, err.Error())
-			}
+	var errs []error
+	for _, object := range renderQuota(app) {
+		_, _, err := c.apply(app, object)
+		if err != nil {
+			errs = append(errs, err)
 		}
 	}
 	log.Infof("Sync quota config done for %s", app.Namespace)
+	return types.NewErrors(errs...)
+}
 
-	return nil
+// removeTraffic delete the service, virtualservice, destinationrule, rbac and quota objects
+// of an application which only has DaemonSets
+func (c *controller) removeTraffic(app *v3.Application) error {
+	var errs []error
+	for kind, name := range map[string]string{
+		ServiceKind.Kind:            app.Name + "-" + "service",
+		ServiceRoleKind.Kind:        app.Name + "-" + "servicerole",
+		ServiceRoleBindingKind.Kind: app.Name + "-" + "servicerolebinding",
+		VirtualServiceKind.Kind:     app.Name + "-" + "vs",
+		DestinationRuleKind.Kind:    app.Name + "-" + "destinationrule",
+		InstanceKind.Kind:           app.Name + "-" + "quotainstance",
+		QuotaSpecKind.Kind:          app.Name + "-" + "quotaspec",
+		QuotaSpecBindingKind.Kind:   app.Name + "-" + "quotaspecbinding",
+		HandlerKind.Kind:            app.Name + "-" + "quotahandler",
+		RuleKind.Kind:               app.Name + "-" + "quotarule",
+	} {
+		errs = append(errs, c.remove(app, kind, name))
+	}
+	return types.NewErrors(errs...)
 }
 
-// sync trusted workload
+// sync trusted workload, the workload called component.Name is created by the user and only
+// labeled into the application, workloadType tells its kind
 func (c *controller) syncTrustedWorkload(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) error {
-	resourceWorkloadType := "deployment"
-	if resourceWorkloadType == "deployment" {
-		deploy, err := c.deploymentLister.Get(app.Namespace, component.Name)
+	key := app.Name + "-" + "workload"
+	if component.WorkloadType == WorkloadTypeDaemonSet {
+		daemonset, err := c.daemonSetLister.Get(app.Namespace, component.Name)
 		if err != nil {
-			log.Errorf("Get trusted deploy for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+			log.Errorf("Get trusted daemonset for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
 			return err
 		}
-		*ref = *(metav1.NewControllerRef(deploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
-		/*		ref.Name = deploy.Name
-				ref.APIVersion = "apps/v1beta2"
-				ref.Kind = "Deployment"
-				ref.UID = deploy.ObjectMeta.UID*/
-		object := deploy.DeepCopy()
-		key := app.Name + "-" + "workload"
-
-		if val, _ := object.Spec.Template.Labels["app"]; val != key {
+		*ref = *(metav1.NewControllerRef(daemonset, DaemonSetKind))
+		if val, _ := daemonset.Spec.Template.Labels["app"]; val != key {
+			object := daemonset.DeepCopy()
+			if object.Spec.Template.Labels == nil {
+				object.Spec.Template.Labels = make(map[string]string)
+			}
 			object.Spec.Template.Labels["app"] = key
-			newdeploy, err := c.deploymentClient.Update(object)
+			newdaemonset, err := c.daemonSetClient.Update(object)
+			c.recordEvent(app, ActionUpdate, DaemonSetKind.Kind, object.Name, err)
 			if err != nil {
-				log.Errorf("Update trusted deploy for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+				log.Errorf("Update trusted daemonset for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
 				return err
 			}
-			*ref = *(metav1.NewControllerRef(newdeploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
+			*ref = *(metav1.NewControllerRef(newdaemonset, DaemonSetKind))
 		}
+		return nil
 	}
 
+	deploy, err := c.deploymentLister.Get(app.Namespace, component.Name)
+	if err != nil {
+		log.Errorf("Get trusted deploy for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+		return err
+	}
+	*ref = *(metav1.NewControllerRef(deploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
+	/*		ref.Name = deploy.Name
+			ref.APIVersion = "apps/v1beta2"
+			ref.Kind = "Deployment"
+			ref.UID = deploy.ObjectMeta.UID*/
+	object := deploy.DeepCopy()
+
+	if val, _ := object.Spec.Template.Labels["app"]; val != key {
+		object.Spec.Template.Labels["app"] = key
+		newdeploy, err := c.deploymentClient.Update(object)
+		c.recordEvent(app, ActionUpdate, "Deployment", object.Name, err)
+		if err != nil {
+			log.Errorf("Update trusted deploy for %s error : %s", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
+			return err
+		}
+		*ref = *(metav1.NewControllerRef(newdeploy, v1beta2.SchemeGroupVersion.WithKind("Deployment")))
+	}
 	return nil
 }
 
 // zk update component state delete not exist version
-func (c *controller) gc(namespace string, deletelist []string) (errlist []string) {
+func (c *controller) gc(app *v3.Application, deletelist []string, resources map[string]v3.ComponentResources) (errlist []string) {
 	for _, i := range deletelist {
-		slices := strings.Split(i, "_")
-		workloadname := slices[0] + "-" + slices[1] + "-" + "workload-" + slices[2]
-		deletePolicy := metav1.DeletePropagationBackground
-		err := c.deploymentClient.DeleteNamespaced(namespace, workloadname, &metav1.DeleteOptions{
-			PropagationPolicy: &deletePolicy,
-		})
+		workloadname := resources[i].Workload
+		if workloadname == "" {
+			// entry kept by a failed gc, the key is app_component_version
+			var ok bool
+			workloadname, ok = workloadOfKey(app.Name, i)
+			if !ok {
+				log.Errorf("Can not get workload of component version %s, drop it", i)
+				continue
+			}
+		}
+		kind, err := c.deleteWorkload(app.Namespace, workloadname)
+		if kind == "" && err == nil {
+			continue
+		}
+		c.recordEvent(app, ActionDelete, kind, workloadname, err)
 		if err != nil {
 			log.Errorf("Delete Workload %s failed errinfo: %v", workloadname, err)
 			errlist = append(errlist, i)
@@ -768,11 +729,22 @@ func (c *controller) gc(namespace string, deletelist []string) (errlist []string
 	return
 }
 
+// workloadOfKey get the workload name from a component version key app_component_version,
+// the version is taken after the last underscore since the names may contain one
+func workloadOfKey(appName, key string) (string, bool) {
+	rest := strings.TrimPrefix(key, appName+"_")
+	index := strings.LastIndex(rest, "_")
+	if rest == key || index <= 0 || index == len(rest)-1 {
+		return "", false
+	}
+	return appName + "-" + rest[:index] + "-" + "workload-" + rest[index+1:], true
+}
+
 // zk fucing
 func (c *controller) syncFusing(podname, namespace string, set bool) {
 	pod, err := c.podLister.Get(namespace, podname)
 	if err != nil {
-		log.Errorln("Get pod for namespace %s pod %s Error: %s", namespace, podname, err.Error())
+		log.Errorf("Get pod for namespace %s pod %s Error: %s", namespace, podname, err.Error())
 	} else {
 		if set {
 			_, ok := pod.Labels["inpool"]
@@ -783,7 +755,7 @@ func (c *controller) syncFusing(podname, namespace string, set bool) {
 			pod.Labels["inpool"] = "yes"
 			_, err = c.podClient.Update(pod)
 			if err != nil {
-				log.Errorf("Update pod %s for namespace %s Error: %s", podname)
+				log.Errorf("Update pod %s for namespace %s Error: %s", podname, namespace, err.Error())
 			}
 			return
 		}
@@ -792,7 +764,7 @@ func (c *controller) syncFusing(podname, namespace string, set bool) {
 			delete(pod.Labels, "inpool")
 			_, err = c.podClient.Update(pod)
 			if err != nil {
-				log.Errorf("Update pod %s for namespace %s Error: %s", podname)
+				log.Errorf("Update pod %s for namespace %s Error: %s", podname, namespace, err.Error())
 			}
 		}
 	}
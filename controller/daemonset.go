@@ -0,0 +1,122 @@
+package controller
+
+import (
+	"fmt"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// WorkloadTypeDaemonSet component workloadType rendered as DaemonSet, one pod on every node selected by the schedulePolicy
+const WorkloadTypeDaemonSet v3.WorkloadType = "DaemonSet"
+
+// DaemonSet workload settings
+const (
+	// SettingUpdateStrategy RollingUpdate (default) or OnDelete
+	SettingUpdateStrategy = "updateStrategy"
+	// SettingMaxUnavailable number or percentage of nodes whose pod may be unavailable during a rolling update
+	SettingMaxUnavailable = "maxUnavailable"
+)
+
+// NewDaemonSetObject Use for generate DaemonSetObject, the pod template is the one of NewDeployObject.
+// The pods are not part of the application service pool, node agents do not serve the ingress traffic.
+func NewDaemonSetObject(component *v3.Component, app *v3.Application) (appsv1beta2.DaemonSet, error) {
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		return appsv1beta2.DaemonSet{}, err
+	}
+	template := deploy.Spec.Template
+	outOfPool(&template)
+
+	daemonset := appsv1beta2.DaemonSet{
+		ObjectMeta: deploy.ObjectMeta,
+		Spec: appsv1beta2.DaemonSetSpec{
+			Selector: deploy.Spec.Selector,
+			Template: template,
+			UpdateStrategy: appsv1beta2.DaemonSetUpdateStrategy{
+				Type: appsv1beta2.RollingUpdateDaemonSetStrategyType,
+			},
+		},
+	}
+	switch strategy := workloadSetting(component, SettingUpdateStrategy); strategy {
+	case "", string(appsv1beta2.RollingUpdateDaemonSetStrategyType):
+	case string(appsv1beta2.OnDeleteDaemonSetStrategyType):
+		daemonset.Spec.UpdateStrategy.Type = appsv1beta2.OnDeleteDaemonSetStrategyType
+	default:
+		return appsv1beta2.DaemonSet{}, fmt.Errorf("%s %q of component %s is invalid, must be RollingUpdate or OnDelete", SettingUpdateStrategy, strategy, component.Name)
+	}
+	if value := workloadSetting(component, SettingMaxUnavailable); value != "" && daemonset.Spec.UpdateStrategy.Type == appsv1beta2.RollingUpdateDaemonSetStrategyType {
+		maxUnavailable, err := parseMaxUnavailable(value)
+		if err != nil {
+			return appsv1beta2.DaemonSet{}, fmt.Errorf("%s %q of component %s is invalid, %s", SettingMaxUnavailable, value, component.Name, err.Error())
+		}
+		daemonset.Spec.UpdateStrategy.RollingUpdate = &appsv1beta2.RollingUpdateDaemonSet{MaxUnavailable: &maxUnavailable}
+	}
+	return daemonset, nil
+}
+
+// outOfPool drop the inpool label, the application service does not select the pods of template
+func outOfPool(template *corev1.PodTemplateSpec) {
+	labels := make(map[string]string)
+	for k, v := range template.Labels {
+		if k != "inpool" {
+			labels[k] = v
+		}
+	}
+	template.Labels = labels
+}
+
+// parseMaxUnavailable accept a positive number or a percentage between 1% and 100%
+func parseMaxUnavailable(value string) (intstr.IntOrString, error) {
+	maxUnavailable := intstr.Parse(value)
+	scaled, err := intstr.GetValueFromIntOrPercent(&maxUnavailable, 100, true)
+	if err != nil {
+		return maxUnavailable, err
+	}
+	if scaled < 1 || (maxUnavailable.Type == intstr.String && scaled > 100) {
+		return maxUnavailable, fmt.Errorf("must be a positive number or a percentage between 1%% and 100%%")
+	}
+	return maxUnavailable, nil
+}
+
+// servesTraffic report whether app has a component behind the application service, an application
+// made only of DaemonSets, Jobs and CronJobs has no service, virtualservice, rbac or quota
+func servesTraffic(app *v3.Application) bool {
+	for _, component := range app.Spec.Components {
+		if len(component.Containers) == 0 || scalable(component.WorkloadType) {
+			return true
+		}
+	}
+	return len(app.Spec.Components) == 0
+}
+
+// scalable report whether the workload of workloadType has replicas, the others get no hpa
+// and do not serve the application traffic
+func scalable(workloadType v3.WorkloadType) bool {
+	switch workloadType {
+	case WorkloadTypeDaemonSet, WorkloadTypeJob, WorkloadTypeCronJob:
+		return false
+	}
+	return true
+}
+
+// daemonSetRolloutStatus is the same check kubectl rollout status does, OnDelete daemonsets
+// are complete once the spec is observed since their pods are only replaced by hand
+func daemonSetRolloutStatus(daemonset *appsv1beta2.DaemonSet) (rolling bool, reason, message string) {
+	if daemonset.Generation > daemonset.Status.ObservedGeneration {
+		return true, "RollingOut", "waiting for daemonset spec update to be observed"
+	}
+	if daemonset.Spec.UpdateStrategy.Type == appsv1beta2.OnDeleteDaemonSetStrategyType {
+		return false, "OnDelete", ""
+	}
+	desired := daemonset.Status.DesiredNumberScheduled
+	if daemonset.Status.UpdatedNumberScheduled < desired {
+		return true, "RollingOut", fmt.Sprintf("%d of %d updated pods have been scheduled", daemonset.Status.UpdatedNumberScheduled, desired)
+	}
+	if daemonset.Status.NumberAvailable < desired {
+		return true, "RollingOut", fmt.Sprintf("%d of %d updated pods are available", daemonset.Status.NumberAvailable, desired)
+	}
+	return false, "RolloutComplete", ""
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+)
+
+// Defaults written into the application by SetDefaults, the generators fall back to the same values
+var (
+	DefaultCPU                = "500m"
+	DefaultMemory             = "200Mi"
+	DefaultRetryAttempts      = 3
+	DefaultRetryPerTryTimeout = "10s"
+	DefaultLoadBalancer       = v3.SimpleLBRoundRobin
+	// DefaultNodeSelector of every workload type but DaemonSet, type is GPU whenever a container requests gpu
+	DefaultNodeSelector = map[string]string{"user": "SP", "type": "cpu"}
+)
+
+// SetDefaults make the implicit defaults of app explicit, values set by the user are kept.
+// It is used by the mutating webhook on admission and by the controller before rendering,
+// so applications created before the webhook render the same.
+func SetDefaults(app *v3.Application) {
+	for i := range app.Spec.Components {
+		component := &app.Spec.Components[i]
+		if len(component.Containers) == 0 {
+			// trusted workload, not rendered by the controller
+			continue
+		}
+		gpu := false
+		for j := range component.Containers {
+			resources := &component.Containers[j].Resources
+			if resources.Cpu == "" {
+				resources.Cpu = DefaultCPU
+			}
+			if resources.Memory == "" {
+				resources.Memory = DefaultMemory
+			}
+			if resources.Gpu > 0 {
+				gpu = true
+			}
+		}
+
+		// a key set to an empty string by the user stays, it removes the default from the pods
+		traits := &component.ComponentTraits
+		if component.WorkloadType == WorkloadTypeDaemonSet && !gpu {
+			continue
+		}
+		if traits.SchedulePolicy == nil {
+			traits.SchedulePolicy = new(v3.SchedulePolicy)
+		}
+		if traits.SchedulePolicy.NodeSelector == nil {
+			traits.SchedulePolicy.NodeSelector = make(map[string]string)
+		}
+		if component.WorkloadType != WorkloadTypeDaemonSet {
+			for k, v := range DefaultNodeSelector {
+				if _, ok := traits.SchedulePolicy.NodeSelector[k]; !ok {
+					traits.SchedulePolicy.NodeSelector[k] = v
+				}
+			}
+		}
+		if gpu {
+			traits.SchedulePolicy.NodeSelector["type"] = "GPU"
+		}
+	}
+
+	traits := &app.Spec.OptTraits
+	if traits.HTTPRetry == nil {
+		traits.HTTPRetry = &v3.HTTPRetry{
+			Attempts:      DefaultRetryAttempts,
+			PerTryTimeout: DefaultRetryPerTryTimeout,
+		}
+	}
+	if traits.LoadBalancer == nil {
+		traits.LoadBalancer = &v3.LoadBalancerSettings{Simple: DefaultLoadBalancer}
+	}
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+)
+
+func TestSetDefaultsNodeSelector(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(c *v3.Component)
+		want   map[string]string
+	}{
+		{name: "default", want: map[string]string{"user": "SP", "type": "cpu"}},
+		{name: "gpu", mutate: func(c *v3.Component) {
+			c.Containers[0].Resources.Gpu = 1
+		}, want: map[string]string{"user": "SP", "type": "GPU"}},
+		{name: "user values and a removed default", mutate: func(c *v3.Component) {
+			c.ComponentTraits.SchedulePolicy = &v3.SchedulePolicy{NodeSelector: map[string]string{"user": "", "zone": "a"}}
+		}, want: map[string]string{"user": "", "type": "cpu", "zone": "a"}},
+		{name: "daemonset", mutate: func(c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+		}},
+		{name: "daemonset with gpu", mutate: func(c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+			c.Containers[0].Resources.Gpu = 1
+		}, want: map[string]string{"type": "GPU"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication(func(app *v3.Application, c *v3.Component) {
+				if tt.mutate != nil {
+					tt.mutate(c)
+				}
+			})
+			SetDefaults(app)
+			component := &app.Spec.Components[0]
+			var got map[string]string
+			if component.ComponentTraits.SchedulePolicy != nil {
+				got = component.ComponentTraits.SchedulePolicy.NodeSelector
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("nodeSelector = %v, want %v", got, tt.want)
+			}
+			// the pods get the persisted selector without the removed keys
+			rendered := getNodeSelector(component)
+			for k, v := range tt.want {
+				if v != "" && rendered[k] != v {
+					t.Errorf("rendered nodeSelector = %v, want %s=%s", rendered, k, v)
+				}
+			}
+		})
+	}
+}
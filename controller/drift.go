@@ -0,0 +1,243 @@
+package controller
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+)
+
+// ReasonDrift event reason of an owned object changed or deleted out of band
+const ReasonDrift string = "DriftDetected"
+
+// watchOwned enqueue the owning application when one of its generated objects is
+// edited or deleted by someone else, the sync restores the desired state.
+// A workload whose status only changed enqueues it with enqueueStatus, the conditions are recomputed
+// without applying anything.
+// Objects are mapped back through the controller OwnerReference, the hpa, the headless service and
+// the jobs of a cronjob are owned by their workload which is owned by the application.
+func (c *controller) watchOwned(enqueue, enqueueStatus func(namespace, name string)) {
+	handler := cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(old, obj interface{}) {
+			oldAccessor, err := meta.Accessor(old)
+			if err != nil {
+				return
+			}
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return
+			}
+			// periodic resync
+			if oldAccessor.GetResourceVersion() == accessor.GetResourceVersion() {
+				return
+			}
+			if ownedChanged(old, obj, oldAccessor, accessor) {
+				c.enqueueOwner(accessor, enqueue)
+				return
+			}
+			if statusChanged(old, obj) {
+				c.enqueueOwner(accessor, enqueueStatus)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return
+			}
+			c.enqueueOwner(accessor, enqueue)
+		},
+	}
+
+	for _, informer := range []cache.SharedIndexInformer{
+		c.deploymentClient.Controller().Informer(),
+		c.statefulSetClient.Controller().Informer(),
+		c.daemonSetClient.Controller().Informer(),
+		c.jobClient.Controller().Informer(),
+		c.cronJobClient.Controller().Informer(),
+		c.serviceClient.Controller().Informer(),
+		c.configmapClient.Controller().Informer(),
+		c.secretClient.Controller().Informer(),
+		c.claimClient.Controller().Informer(),
+		c.autoscaleClient.Controller().Informer(),
+		c.virtualServiceClient.Controller().Informer(),
+		c.destClient.Controller().Informer(),
+		c.serviceRoleClient.Controller().Informer(),
+		c.serviceRoleBindingClient.Controller().Informer(),
+		c.instanceClient.Controller().Informer(),
+		c.quotaspecClient.Controller().Informer(),
+		c.quotaspecbindingClient.Controller().Informer(),
+		c.handlerClient.Controller().Informer(),
+		c.ruleClient.Controller().Informer(),
+	} {
+		informer.AddEventHandler(handler)
+	}
+}
+
+// enqueueOwner find the application owning object, directly or through its workload
+func (c *controller) enqueueOwner(object metav1.Object, enqueue func(namespace, name string)) {
+	c.enqueueRef(object, metav1.GetControllerOf(object), enqueue)
+}
+
+// enqueueRef follow ref up to the application, through at most a job and its cronjob
+func (c *controller) enqueueRef(object metav1.Object, ref *metav1.OwnerReference, enqueue func(namespace, name string)) {
+	for i := 0; ref != nil && i < 2 && workloadKind(ref.Kind); i++ {
+		workload, err := c.getWorkloadOfKind(object.GetNamespace(), ref.Kind, ref.Name)
+		if err != nil {
+			return
+		}
+		ref = metav1.GetControllerOf(workload)
+	}
+	if ref == nil || ref.Kind != v3.ApplicationGroupVersionKind.Kind {
+		return
+	}
+	log.Debugf("Owned object %s changed, enqueue application %s", object.GetNamespace()+":"+object.GetName(), object.GetNamespace()+":"+ref.Name)
+	enqueue(object.GetNamespace(), ref.Name)
+}
+
+// watchPods enqueue the application of a pod with enqueueStatus when the pod is created, deleted
+// or its readiness, restarts or failing containers change, the conditions of the application count them
+func (c *controller) watchPods(enqueueStatus func(namespace, name string)) {
+	handler := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if pod, ok := obj.(*corev1.Pod); ok {
+			c.enqueueRef(pod, podWorkloadRef(pod), enqueueStatus)
+		}
+	}
+	c.podClient.Controller().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: handler,
+		UpdateFunc: func(old, obj interface{}) {
+			oldPod, ok := old.(*corev1.Pod)
+			if !ok {
+				return
+			}
+			pod, ok := obj.(*corev1.Pod)
+			if !ok || !podChanged(oldPod, pod) {
+				return
+			}
+			handler(obj)
+		},
+		DeleteFunc: handler,
+	})
+}
+
+// podWorkloadRef the controller of pod, a replicaset is replaced by its deployment, which is the name of
+// the replicaset without the pod-template-hash suffix
+func podWorkloadRef(pod *corev1.Pod) *metav1.OwnerReference {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "ReplicaSet" {
+		return ref
+	}
+	hash := pod.Labels[appsv1beta2.DefaultDeploymentUniqueLabelKey]
+	if hash == "" || !strings.HasSuffix(ref.Name, "-"+hash) {
+		return nil
+	}
+	return &metav1.OwnerReference{Kind: DeploymentKind.Kind, Name: strings.TrimSuffix(ref.Name, "-"+hash)}
+}
+
+// podChanged report whether pod changed the way podsStatus counts it
+func podChanged(old, pod *corev1.Pod) bool {
+	return podReady(old) != podReady(pod) ||
+		(old.DeletionTimestamp == nil) != (pod.DeletionTimestamp == nil) ||
+		initContainerFailure(old) != initContainerFailure(pod) ||
+		!reflect.DeepEqual(containerStates(old), containerStates(pod))
+}
+
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// containerStates the restart count and waiting reason of every container of pod
+func containerStates(pod *corev1.Pod) []string {
+	var states []string
+	for _, cs := range pod.Status.ContainerStatuses {
+		reason := ""
+		if cs.State.Waiting != nil {
+			reason = cs.State.Waiting.Reason
+		}
+		states = append(states, fmt.Sprintf("%s/%d/%s", cs.Name, cs.RestartCount, reason))
+	}
+	return states
+}
+
+// ownedChanged report whether labels, annotations or the content besides metadata and status changed,
+// the desired state has to be applied again.
+// Objects with a generation, e.g. workloads, bump it on spec changes only, status only updates like
+// pods becoming ready are left to statusChanged. Services, configmaps and secrets have none, their content is compared.
+func ownedChanged(old, obj interface{}, oldAccessor, accessor metav1.Object) bool {
+	if !reflect.DeepEqual(oldAccessor.GetLabels(), accessor.GetLabels()) ||
+		!reflect.DeepEqual(oldAccessor.GetAnnotations(), accessor.GetAnnotations()) {
+		return true
+	}
+	if accessor.GetGeneration() != 0 {
+		return oldAccessor.GetGeneration() != accessor.GetGeneration()
+	}
+	oldContent, err := objectContent(old)
+	if err != nil {
+		return true
+	}
+	content, err := objectContent(obj)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(oldContent, content)
+}
+
+// objectContent the fields of obj besides metadata and status, e.g. spec of a service or data of a configmap
+func objectContent(obj interface{}) (map[string]interface{}, error) {
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	delete(content, "metadata")
+	delete(content, "status")
+	return content, nil
+}
+
+// statusChanged report whether the status of a workload changed, e.g. readyReplicas, updatedReplicas,
+// the conditions or the last run of a job, which the conditions of the application are computed from.
+// The status of other owned objects is not reported.
+func statusChanged(old, obj interface{}) bool {
+	switch obj.(type) {
+	case *appsv1beta2.Deployment, *appsv1beta2.StatefulSet, *appsv1beta2.DaemonSet, *batchv1.Job, *batchv1beta1.CronJob:
+	default:
+		return false
+	}
+	oldStatus, err := runtime.DefaultUnstructuredConverter.ToUnstructured(old)
+	if err != nil {
+		return true
+	}
+	status, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return true
+	}
+	return !reflect.DeepEqual(oldStatus["status"], status["status"])
+}
+
+// recordDrift emit a warning event on the application for an owned object the sync restored
+func (c *controller) recordDrift(app *v3.Application, kind, name, message string) {
+	log.Infof("Drift of %s %s in application %s: %s", kind, name, app.Namespace+":"+app.Name, message)
+	if c.recorder == nil {
+		return
+	}
+	c.recorder.Eventf(app, corev1.EventTypeWarning, ReasonDrift, "%s %s %s", kind, name, message)
+}
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"testing"
+
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestOwnedChanged(t *testing.T) {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", ResourceVersion: "1", Labels: map[string]string{"app": "demo"}},
+		Spec:       corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80}}},
+	}
+	configmap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "cm", ResourceVersion: "1"}, Data: map[string]string{"a": "1"}}
+	deploy := &appsv1beta2.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", ResourceVersion: "1", Generation: 1}}
+
+	tests := []struct {
+		name   string
+		old    runtime.Object
+		mutate func(obj runtime.Object)
+		want   bool
+	}{
+		{name: "service metadata only", old: service, mutate: func(obj runtime.Object) {
+			obj.(*corev1.Service).Finalizers = []string{"service.kubernetes.io/load-balancer-cleanup"}
+		}, want: false},
+		{name: "service status only", old: service, mutate: func(obj runtime.Object) {
+			obj.(*corev1.Service).Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		}, want: false},
+		{name: "service spec", old: service, mutate: func(obj runtime.Object) {
+			obj.(*corev1.Service).Spec.Ports[0].Port = 8080
+		}, want: true},
+		{name: "service label", old: service, mutate: func(obj runtime.Object) {
+			obj.(*corev1.Service).Labels["app"] = "other"
+		}, want: true},
+		{name: "configmap owner reference only", old: configmap, mutate: func(obj runtime.Object) {
+			obj.(*corev1.ConfigMap).OwnerReferences = []metav1.OwnerReference{{Name: "demo"}}
+		}, want: false},
+		{name: "configmap data", old: configmap, mutate: func(obj runtime.Object) {
+			obj.(*corev1.ConfigMap).Data["a"] = "2"
+		}, want: true},
+		{name: "deployment status only", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Status.ReadyReplicas = 2
+		}, want: false},
+		{name: "deployment spec", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Generation = 2
+		}, want: true},
+		{name: "deployment annotation", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Annotations = map[string]string{"a": "b"}
+		}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := tt.old.DeepCopyObject()
+			tt.mutate(obj)
+			obj.(metav1.Object).SetResourceVersion("2")
+			if got := ownedChanged(tt.old, obj, tt.old.(metav1.Object), obj.(metav1.Object)); got != tt.want {
+				t.Errorf("ownedChanged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStatusChanged(t *testing.T) {
+	deploy := &appsv1beta2.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", ResourceVersion: "1", Generation: 1}}
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "run", ResourceVersion: "1", Generation: 1}}
+	service := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", ResourceVersion: "1"}}
+
+	tests := []struct {
+		name   string
+		old    runtime.Object
+		mutate func(obj runtime.Object)
+		want   bool
+	}{
+		{name: "deployment ready replicas", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Status.ReadyReplicas = 2
+		}, want: true},
+		{name: "deployment updated replicas", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Status.UpdatedReplicas = 1
+		}, want: true},
+		{name: "deployment condition", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Status.Conditions = []appsv1beta2.DeploymentCondition{{Type: appsv1beta2.DeploymentProgressing, Reason: "ProgressDeadlineExceeded"}}
+		}, want: true},
+		{name: "deployment metadata only", old: deploy, mutate: func(obj runtime.Object) {
+			obj.(*appsv1beta2.Deployment).Finalizers = []string{"a"}
+		}, want: false},
+		{name: "job finished", old: job, mutate: func(obj runtime.Object) {
+			obj.(*batchv1.Job).Status.Succeeded = 1
+		}, want: true},
+		{name: "service status", old: service, mutate: func(obj runtime.Object) {
+			obj.(*corev1.Service).Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.0.0.1"}}
+		}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := tt.old.DeepCopyObject()
+			tt.mutate(obj)
+			obj.(metav1.Object).SetResourceVersion("2")
+			if got := statusChanged(tt.old, obj); got != tt.want {
+				t.Errorf("statusChanged = %v, want %v", got, tt.want)
+			}
+			// a status only update is never a drift to apply again
+			if ownedChanged(tt.old, obj, tt.old.(metav1.Object), obj.(metav1.Object)) {
+				t.Errorf("ownedChanged = true for a status only update")
+			}
+		})
+	}
+}
+
+func TestStatusRequests(t *testing.T) {
+	var requests statusRequests
+	requests.request("ns/status", true)
+	requests.request("ns/full", false)
+	requests.request("ns/full", true)
+	requests.request("ns/upgraded", true)
+	requests.request("ns/upgraded", false)
+
+	for key, want := range map[string]bool{"ns/status": true, "ns/full": false, "ns/upgraded": false, "ns/informer": false} {
+		if got := requests.take(key); got != want {
+			t.Errorf("take(%s) = %v, want %v", key, got, want)
+		}
+	}
+	// taken keys get a full sync the next time
+	if requests.take("ns/status") {
+		t.Errorf("take(ns/status) = true after it was taken")
+	}
+}
+
+func TestPodWorkloadRef(t *testing.T) {
+	controllerRef := func(kind, name string) []metav1.OwnerReference {
+		isController := true
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &isController}}
+	}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want *metav1.OwnerReference
+	}{
+		{name: "deployment", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: controllerRef("ReplicaSet", "demo-web-workload-v1-5d8f9c"),
+			Labels:          map[string]string{"pod-template-hash": "5d8f9c"},
+		}}, want: &metav1.OwnerReference{Kind: "Deployment", Name: "demo-web-workload-v1"}},
+		{name: "replicaset without hash", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: controllerRef("ReplicaSet", "demo-web-workload-v1-5d8f9c"),
+		}}},
+		{name: "statefulset", pod: &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: controllerRef("StatefulSet", "demo-db-workload-v1"),
+		}}, want: &metav1.OwnerReference{Kind: "StatefulSet", Name: "demo-db-workload-v1"}},
+		{name: "no controller", pod: &corev1.Pod{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := podWorkloadRef(tt.pod)
+			if (got == nil) != (tt.want == nil) || got != nil && (got.Kind != tt.want.Kind || got.Name != tt.want.Name) {
+				t.Errorf("podWorkloadRef = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodChanged(t *testing.T) {
+	pod := &corev1.Pod{Status: corev1.PodStatus{
+		Conditions:        []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		ContainerStatuses: []corev1.ContainerStatus{{Name: "web"}},
+	}}
+	tests := []struct {
+		name   string
+		mutate func(pod *corev1.Pod)
+		want   bool
+	}{
+		{name: "ready", mutate: func(pod *corev1.Pod) {
+			pod.Status.Conditions[0].Status = corev1.ConditionTrue
+		}, want: true},
+		{name: "restarted", mutate: func(pod *corev1.Pod) {
+			pod.Status.ContainerStatuses[0].RestartCount = 1
+		}, want: true},
+		{name: "crash looping", mutate: func(pod *corev1.Pod) {
+			pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+		}, want: true},
+		{name: "terminating", mutate: func(pod *corev1.Pod) {
+			now := metav1.Now()
+			pod.DeletionTimestamp = &now
+		}, want: true},
+		{name: "pod ip only", mutate: func(pod *corev1.Pod) {
+			pod.Status.PodIP = "10.0.0.1"
+		}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := pod.DeepCopy()
+			tt.mutate(updated)
+			if got := podChanged(pod, updated); got != tt.want {
+				t.Errorf("podChanged = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Env sources, the fromParam of an env is written source:reference. A fromParam without source is a field path.
+const (
+	// EnvSourceConfigMapKey configMapKeyRef:<configmap>/<key>, one key of a configmap of the namespace
+	EnvSourceConfigMapKey = "configMapKeyRef"
+	// EnvSourceSecretKey secretKeyRef:<secret>/<key>, one key of a secret of the namespace
+	EnvSourceSecretKey = "secretKeyRef"
+	// EnvSourceResourceField resourceFieldRef:<resource>[/<divisor>], a limit or request of the container, e.g. limits.memory/1Mi
+	EnvSourceResourceField = "resourceFieldRef"
+	// EnvSourceField fieldRef:<path>, a downward api field of the pod, e.g. metadata.labels['app']
+	EnvSourceField = "fieldRef"
+	// EnvSourceConfigMap configMapRef:<configmap>, every key of a configmap, the env name is the prefix of the variables
+	EnvSourceConfigMap = "configMapRef"
+	// EnvSourceSecret secretRef:<secret>, every key of a secret, the env name is the prefix of the variables
+	EnvSourceSecret = "secretRef"
+)
+
+// EnvFieldPaths the downward api fields an env may reference, besides metadata.labels['<key>'] and metadata.annotations['<key>']
+var EnvFieldPaths = []string{"metadata.name", "metadata.namespace", "metadata.uid", "spec.nodeName", "spec.serviceAccountName", "status.hostIP", "status.podIP"}
+
+// EnvResourceFields the container resources an env may reference
+var EnvResourceFields = []string{"limits.cpu", "limits.memory", "limits.ephemeral-storage", "requests.cpu", "requests.memory", "requests.ephemeral-storage"}
+
+// envSource is a parsed fromParam
+type envSource struct {
+	source string
+	// name of the configmap or secret, the field path or the resource
+	name string
+	// key of the configmap or secret, the divisor of the resource
+	key string
+}
+
+// errUnknownEnvSource fromParam is neither source:reference nor a field path, e.g. the name of a component parameter.
+// The value of the env is used instead, as it always was.
+var errUnknownEnvSource = fmt.Errorf("is not a supported source or field path")
+
+// parseEnvSource parse fromParam, a bare field path is a fieldRef
+func parseEnvSource(fromParam string) (envSource, error) {
+	source, ref := EnvSourceField, fromParam
+	explicit := false
+	if i := strings.Index(fromParam, ":"); i >= 0 {
+		source, ref = fromParam[:i], fromParam[i+1:]
+		explicit = true
+	}
+	s := envSource{source: source, name: ref}
+	switch source {
+	case EnvSourceConfigMapKey, EnvSourceSecretKey:
+		i := strings.Index(ref, "/")
+		if i <= 0 || i == len(ref)-1 {
+			return s, fmt.Errorf("must be %s:<name>/<key>", source)
+		}
+		s.name, s.key = ref[:i], ref[i+1:]
+	case EnvSourceResourceField:
+		if i := strings.Index(ref, "/"); i >= 0 {
+			s.name, s.key = ref[:i], ref[i+1:]
+			if _, err := resource.ParseQuantity(s.key); err != nil {
+				return s, fmt.Errorf("divisor %q is invalid: %s", s.key, err.Error())
+			}
+		}
+		if !contains(EnvResourceFields, s.name) {
+			return s, fmt.Errorf("resource must be one of %s", strings.Join(EnvResourceFields, ", "))
+		}
+	case EnvSourceField:
+		if !validFieldPath(ref) && !explicit {
+			return s, errUnknownEnvSource
+		}
+		if !validFieldPath(ref) {
+			return s, fmt.Errorf("field path must be one of %s, metadata.labels['<key>'] or metadata.annotations['<key>']", strings.Join(EnvFieldPaths, ", "))
+		}
+	case EnvSourceConfigMap, EnvSourceSecret:
+		if ref == "" {
+			return s, fmt.Errorf("must be %s:<name>", source)
+		}
+	default:
+		return s, errUnknownEnvSource
+	}
+	return s, nil
+}
+
+func validFieldPath(path string) bool {
+	for _, prefix := range []string{"metadata.labels['", "metadata.annotations['"} {
+		if strings.HasPrefix(path, prefix) && strings.HasSuffix(path, "']") && len(path) > len(prefix)+2 {
+			return true
+		}
+	}
+	return contains(EnvFieldPaths, path)
+}
+
+// getContainerEnvs convert the env of cc, configMapRef and secretRef entries are the envFrom of the container.
+// An env whose fromParam does not parse has its value.
+func getContainerEnvs(cc v3.ComponentContainer) (envs []corev1.EnvVar, envFrom []corev1.EnvFromSource, err error) {
+	for _, ccenv := range cc.Env {
+		if ccenv.FromParam == "" {
+			if ccenv.Name != "" && ccenv.Value != "" {
+				envs = append(envs, corev1.EnvVar{Name: ccenv.Name, Value: ccenv.Value})
+			}
+			continue
+		}
+		s, err := parseEnvSource(ccenv.FromParam)
+		if err != nil {
+			// reported by unsupportedEnvSources
+			log.Warnf("Env %s of container %s: fromParam %q %s, the value is used", ccenv.Name, cc.Name, ccenv.FromParam, err.Error())
+			if ccenv.Name != "" && ccenv.Value != "" {
+				envs = append(envs, corev1.EnvVar{Name: ccenv.Name, Value: ccenv.Value})
+			}
+			continue
+		}
+		switch s.source {
+		case EnvSourceConfigMap:
+			envFrom = append(envFrom, corev1.EnvFromSource{
+				Prefix:       ccenv.Name,
+				ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: s.name}},
+			})
+			continue
+		case EnvSourceSecret:
+			envFrom = append(envFrom, corev1.EnvFromSource{
+				Prefix:    ccenv.Name,
+				SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: s.name}},
+			})
+			continue
+		}
+		if ccenv.Name == "" {
+			continue
+		}
+		valueFrom := &corev1.EnvVarSource{}
+		switch s.source {
+		case EnvSourceConfigMapKey:
+			valueFrom.ConfigMapKeyRef = &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: s.name}, Key: s.key}
+		case EnvSourceSecretKey:
+			valueFrom.SecretKeyRef = &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: s.name}, Key: s.key}
+		case EnvSourceResourceField:
+			valueFrom.ResourceFieldRef = &corev1.ResourceFieldSelector{Resource: s.name}
+			if s.key != "" {
+				valueFrom.ResourceFieldRef.Divisor = resource.MustParse(s.key)
+			}
+		case EnvSourceField:
+			valueFrom.FieldRef = &corev1.ObjectFieldSelector{FieldPath: s.name}
+		}
+		envs = append(envs, corev1.EnvVar{Name: ccenv.Name, ValueFrom: valueFrom})
+	}
+	return envs, envFrom, nil
+}
+
+// unsupportedEnvSources the env entries of component whose fromParam does not parse, they get their value instead
+func unsupportedEnvSources(component *v3.Component) []string {
+	var entries []string
+	for _, container := range component.Containers {
+		for _, env := range container.Env {
+			if env.FromParam == "" {
+				continue
+			}
+			if _, err := parseEnvSource(env.FromParam); err != nil {
+				entries = append(entries, fmt.Sprintf("env %s of container %s fromParam %q", env.Name, container.Name, env.FromParam))
+			}
+		}
+	}
+	return entries
+}
+
+// componentReferences the configmaps and secrets the env and config files of component read, as Kind/name
+func componentReferences(component *v3.Component) []string {
+	var refs []string
+	for _, container := range component.Containers {
+		for _, file := range container.Config {
+			s, err := parseConfigSource(file)
+			if err != nil || s.source != ConfigSecretKey {
+				continue
+			}
+			if ref := SecretKind.Kind + "/" + s.name; !contains(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+		for _, env := range container.Env {
+			if env.FromParam == "" {
+				continue
+			}
+			s, err := parseEnvSource(env.FromParam)
+			if err != nil {
+				continue
+			}
+			var ref string
+			switch s.source {
+			case EnvSourceConfigMapKey, EnvSourceConfigMap:
+				ref = ConfigMapKind.Kind + "/" + s.name
+			case EnvSourceSecretKey, EnvSourceSecret:
+				ref = SecretKind.Kind + "/" + s.name
+			default:
+				continue
+			}
+			if !contains(refs, ref) {
+				refs = append(refs, ref)
+			}
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// missingReferences the references of component which do not exist in the namespace of app,
+// the pods can not start until they are created
+func (c *controller) missingReferences(component *v3.Component, app *v3.Application) ([]string, error) {
+	var missing []string
+	for _, ref := range componentReferences(component) {
+		found, err := c.referenceExists(app.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			missing = append(missing, ref)
+		}
+	}
+	return missing, nil
+}
+
+func (c *controller) referenceExists(namespace, ref string) (bool, error) {
+	i := strings.Index(ref, "/")
+	kind, name := ref[:i], ref[i+1:]
+	var err error
+	if kind == ConfigMapKind.Kind {
+		_, err = c.configmapLister.Get(namespace, name)
+	} else {
+		_, err = c.secretLister.Get(namespace, name)
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// watchReferences sync the applications of the namespace which read a configmap or secret
+// from their env or config files when it is created or deleted, their status reports the missing ones
+func (c *controller) watchReferences(enqueue func(namespace, name string)) {
+	handler := func(kind string) func(obj interface{}) {
+		return func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return
+			}
+			apps, err := c.applicationLister.List(accessor.GetNamespace(), labels.Everything())
+			if err != nil {
+				log.Errorf("List applications of namespace %s Error : %s", accessor.GetNamespace(), err.Error())
+				return
+			}
+			for _, app := range apps {
+				for i := range app.Spec.Components {
+					if contains(componentReferences(&app.Spec.Components[i]), kind+"/"+accessor.GetName()) {
+						enqueue(app.Namespace, app.Name)
+						break
+					}
+				}
+			}
+		}
+	}
+	for kind, informer := range map[string]cache.SharedIndexInformer{
+		ConfigMapKind.Kind: c.configmapClient.Controller().Informer(),
+		SecretKind.Kind:    c.secretClient.Controller().Informer(),
+	} {
+		informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    handler(kind),
+			DeleteFunc: handler(kind),
+		})
+	}
+}
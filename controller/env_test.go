@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func TestParseEnvSource(t *testing.T) {
+	tests := []struct {
+		name      string
+		fromParam string
+		want      envSource
+		wantErr   error
+		invalid   bool
+	}{
+		{name: "bare field path", fromParam: "status.podIP", want: envSource{source: EnvSourceField, name: "status.podIP"}},
+		{name: "bare label", fromParam: "metadata.labels['app']", want: envSource{source: EnvSourceField, name: "metadata.labels['app']"}},
+		{name: "field ref", fromParam: "fieldRef:metadata.annotations['a/b']", want: envSource{source: EnvSourceField, name: "metadata.annotations['a/b']"}},
+		{name: "configmap key", fromParam: "configMapKeyRef:cm/key", want: envSource{source: EnvSourceConfigMapKey, name: "cm", key: "key"}},
+		{name: "secret key", fromParam: "secretKeyRef:s/a.b", want: envSource{source: EnvSourceSecretKey, name: "s", key: "a.b"}},
+		{name: "resource", fromParam: "resourceFieldRef:limits.memory", want: envSource{source: EnvSourceResourceField, name: "limits.memory"}},
+		{name: "resource divisor", fromParam: "resourceFieldRef:limits.memory/1Mi", want: envSource{source: EnvSourceResourceField, name: "limits.memory", key: "1Mi"}},
+		{name: "configmap", fromParam: "configMapRef:cm", want: envSource{source: EnvSourceConfigMap, name: "cm"}},
+		{name: "secret", fromParam: "secretRef:s", want: envSource{source: EnvSourceSecret, name: "s"}},
+		{name: "component parameter", fromParam: "dbHost", wantErr: errUnknownEnvSource},
+		{name: "unknown bare path", fromParam: "spec.hostname", wantErr: errUnknownEnvSource},
+		{name: "unknown source", fromParam: "param:dbHost", wantErr: errUnknownEnvSource},
+		{name: "configmap key without key", fromParam: "configMapKeyRef:cm", invalid: true},
+		{name: "secret key without name", fromParam: "secretKeyRef:/key", invalid: true},
+		{name: "secret key empty key", fromParam: "secretKeyRef:s/", invalid: true},
+		{name: "unknown resource", fromParam: "resourceFieldRef:limits.gpu", invalid: true},
+		{name: "bad divisor", fromParam: "resourceFieldRef:limits.cpu/x", invalid: true},
+		{name: "explicit unknown field", fromParam: "fieldRef:spec.hostname", invalid: true},
+		{name: "empty label key", fromParam: "fieldRef:metadata.labels['']", invalid: true},
+		{name: "configmap without name", fromParam: "configMapRef:", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseEnvSource(tt.fromParam)
+			switch {
+			case tt.wantErr != nil:
+				if err != tt.wantErr {
+					t.Errorf("parseEnvSource(%q) error = %v, want %v", tt.fromParam, err, tt.wantErr)
+				}
+			case tt.invalid:
+				if err == nil || err == errUnknownEnvSource {
+					t.Errorf("parseEnvSource(%q) error = %v, want it invalid", tt.fromParam, err)
+				}
+			case err != nil:
+				t.Errorf("parseEnvSource(%q) error = %v", tt.fromParam, err)
+			case got != tt.want:
+				t.Errorf("parseEnvSource(%q) = %+v, want %+v", tt.fromParam, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetContainerEnvs(t *testing.T) {
+	cc := v3.ComponentContainer{
+		Name: "web",
+		Env: []v3.CEnvVar{
+			{Name: "PLAIN", Value: "1"},
+			{Name: "EMPTY"},
+			{Name: "POD_IP", FromParam: "status.podIP"},
+			{Name: "PASSWORD", FromParam: "secretKeyRef:db/password"},
+			{Name: "MEMORY", FromParam: "resourceFieldRef:limits.memory/1Mi"},
+			{Name: "DB_HOST", FromParam: "dbHost", Value: "mysql"},
+			{Name: "BROKEN", FromParam: "configMapKeyRef:cm", Value: "fallback"},
+			{Name: "NO_VALUE", FromParam: "dbPort"},
+			{Name: "CFG_", FromParam: "configMapRef:settings"},
+			{FromParam: "secretRef:tokens"},
+		},
+	}
+	envs, envFrom, err := getContainerEnvs(cc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEnvs := []corev1.EnvVar{
+		{Name: "PLAIN", Value: "1"},
+		{Name: "POD_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}}},
+		{Name: "PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{
+			LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"}}},
+		{Name: "MEMORY", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{
+			Resource: "limits.memory", Divisor: resource.MustParse("1Mi")}}},
+		{Name: "DB_HOST", Value: "mysql"},
+		{Name: "BROKEN", Value: "fallback"},
+	}
+	if !reflect.DeepEqual(envs, wantEnvs) {
+		t.Errorf("envs = %+v\nwant %+v", envs, wantEnvs)
+	}
+	wantEnvFrom := []corev1.EnvFromSource{
+		{Prefix: "CFG_", ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}}},
+		{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "tokens"}}},
+	}
+	if !reflect.DeepEqual(envFrom, wantEnvFrom) {
+		t.Errorf("envFrom = %+v\nwant %+v", envFrom, wantEnvFrom)
+	}
+
+	component := &v3.Component{Containers: []v3.ComponentContainer{cc}}
+	wantUnsupported := []string{
+		`env DB_HOST of container web fromParam "dbHost"`,
+		`env BROKEN of container web fromParam "configMapKeyRef:cm"`,
+		`env NO_VALUE of container web fromParam "dbPort"`,
+	}
+	if got := unsupportedEnvSources(component); !reflect.DeepEqual(got, wantUnsupported) {
+		t.Errorf("unsupportedEnvSources = %q, want %q", got, wantUnsupported)
+	}
+}
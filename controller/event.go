@@ -0,0 +1,27 @@
+package controller
+
+import (
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Event action use for event reason
+const (
+	ActionCreate string = "Create"
+	ActionUpdate string = "Update"
+	ActionDelete string = "Delete"
+)
+
+// recordEvent emit a Normal event on the application when err is nil,
+// otherwise a Warning event carrying the api error text.
+// reason looks like SuccessfulCreate / FailedCreate, same as the kubernetes builtin controllers.
+func (c *controller) recordEvent(app *v3.Application, action, kind, name string, err error) {
+	if c.recorder == nil || app == nil {
+		return
+	}
+	if err != nil {
+		c.recorder.Eventf(app, corev1.EventTypeWarning, "Failed"+action, "%s %s %s failed: %s", action, kind, name, err.Error())
+		return
+	}
+	c.recorder.Eventf(app, corev1.EventTypeNormal, "Successful"+action, "%s %s %s", action, kind, name)
+}
@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	"github.com/rancher/norman/types"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// teardown is the lifecycle of application, it owns the finalizer which cleans up
+// the side effects that OwnerReferences can not reach:
+// discovery rules in the shared monitoring/adapter-config and the namespace in ClusterRbacConfig
+type teardown struct {
+	c *controller
+}
+
+// Create nothing to do, the lifecycle adds the finalizer
+func (t *teardown) Create(app *v3.Application) (runtime.Object, error) {
+	return app, nil
+}
+
+// Updated nothing to do, resources are synced by controller.sync
+func (t *teardown) Updated(app *v3.Application) (runtime.Object, error) {
+	return app, nil
+}
+
+// Remove is called before the finalizer is released, an error keeps the finalizer and retry
+func (t *teardown) Remove(app *v3.Application) (runtime.Object, error) {
+	log.Infof("Teardown application %s", app.Namespace+":"+app.Name)
+	err := types.NewErrors(
+		t.c.removeAutoScaleRules(app),
+		t.c.removeClusterRbacNamespace(app),
+	)
+	if err != nil {
+		log.Errorf("Teardown application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+		return app, err
+	}
+	log.Infof("Teardown application %s done", app.Namespace+":"+app.Name)
+	return app, nil
+}
+
+// removeAutoScaleRules drop the discovery rules of every workload of app from adapter-config
+func (c *controller) removeAutoScaleRules(app *v3.Application) error {
+	configmap, err := c.configmapLister.Get("monitoring", "adapter-config")
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	value := configmap.Data["config.yaml"]
+	if value == "" {
+		return nil
+	}
+	config := new(MetricsDiscoveryConfig)
+	err = FromYAML(config, []byte(value))
+	if err != nil {
+		return err
+	}
+
+	// workloads of current versions and of versions not yet collected
+	var workloads []string
+	for _, component := range app.Spec.Components {
+		workloads = append(workloads, app.Name+"-"+component.Name+"-"+"workload"+"-")
+	}
+	for key, resource := range app.Status.ComponentResource {
+		if resource.Workload != "" {
+			workloads = append(workloads, resource.Workload)
+		} else if workload, ok := workloadOfKey(app.Name, key); ok {
+			workloads = append(workloads, workload)
+		}
+	}
+	var rules []DiscoveryRule
+	for _, rule := range config.Rules {
+		if ruleOfWorkloads(rule, app.Namespace, workloads) {
+			log.Infof("Remove discovery rule %s of %s", rule.Name.As, app.Namespace+":"+app.Name)
+			continue
+		}
+		rules = append(rules, rule)
+	}
+	if len(rules) == len(config.Rules) {
+		return nil
+	}
+	config.Rules = rules
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	object := configmap.DeepCopy()
+	object.Data["config.yaml"] = string(data)
+	_, err = c.configmapClient.Update(object)
+	c.recordEvent(app, ActionUpdate, "ConfigMap", object.Name, err)
+	if err != nil {
+		return err
+	}
+	c.reloadAdapter()
+	return nil
+}
+
+// ruleOfWorkloads check the rule generated by generaterule select pods of one of workloads in namespace
+func ruleOfWorkloads(rule DiscoveryRule, namespace string, workloads []string) bool {
+	if !strings.Contains(rule.MetricsQuery, `kubernetes_namespace="`+namespace+`"`) {
+		return false
+	}
+	for _, workload := range workloads {
+		if strings.Contains(rule.MetricsQuery, `kubernetes_pod_name=~"`+workload) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeClusterRbacNamespace drop namespace of app from ClusterRbacConfig inclusion
+// when no other application lives in it
+func (c *controller) removeClusterRbacNamespace(app *v3.Application) error {
+	apps, err := c.applicationLister.List(app.Namespace, labels.Everything())
+	if err != nil {
+		return err
+	}
+	for _, i := range apps {
+		if i.Name != app.Name && i.DeletionTimestamp == nil {
+			log.Debugf("Namespace %s still has application %s, keep it in clusterrbacconfig", app.Namespace, i.Name)
+			return nil
+		}
+	}
+
+	cfg, err := c.clusterconfigLister.Get("", "default")
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	clusterrbacconfig := cfg.DeepCopy()
+	_, labeled := clusterrbacconfig.ObjectMeta.Labels[app.Namespace]
+	var included bool
+	if clusterrbacconfig.Spec.Inclusion != nil {
+		var namespaces []string
+		for _, ns := range clusterrbacconfig.Spec.Inclusion.Namespaces {
+			if ns == app.Namespace {
+				included = true
+				continue
+			}
+			namespaces = append(namespaces, ns)
+		}
+		clusterrbacconfig.Spec.Inclusion.Namespaces = namespaces
+	}
+	if !labeled && !included {
+		return nil
+	}
+	delete(clusterrbacconfig.ObjectMeta.Labels, app.Namespace)
+	clusterrbacconfig.Namespace = "default" //avoid the client-go bug
+	_, err = c.clusterconfigClient.Update(clusterrbacconfig)
+	c.recordEvent(app, ActionUpdate, "ClusterRbacConfig", clusterrbacconfig.Name, err)
+	if err != nil {
+		return err
+	}
+	log.Infof("Remove namespace %s from clusterrbacconfig", app.Namespace)
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	"github.com/rancher/norman/types"
 	log "github.com/sirupsen/logrus"
 	yaml "gopkg.in/yaml.v2"
 	"k8s.io/api/autoscaling/v2beta2"
@@ -57,7 +58,7 @@ func NewAutoScaleInstance(component *v3.Component, app *v3.Application, ref *met
 			},
 			Spec: v2beta2.HorizontalPodAutoscalerSpec{
 				ScaleTargetRef: v2beta2.CrossVersionObjectReference{
-					Kind:       "Deployment",
+					Kind:       ref.Kind,
 					Name:       app.Name + "-" + component.Name + "-" + "workload" + "-" + component.Version,
 					APIVersion: ref.APIVersion,
 				},
@@ -75,8 +76,7 @@ func (c *controller) syncHpa(component *v3.Component, app *v3.Application, ref *
 	//if !(reflect.DeepEqual(component.ComponentTraits.Autoscaling, v3.Autoscaling{})) {
 	if component.ComponentTraits.Autoscaling != nil {
 		log.Infof("Sync hpa for %s", app.Namespace+":"+app.Name+"-"+component.Name)
-		c.syncAutoScaleConfigMap(component, app)
-		c.syncAutoScale(component, app, ref)
+		return types.NewErrors(c.syncAutoScaleConfigMap(component, app), c.syncAutoScale(component, app, ref))
 	}
 	return nil
 }
@@ -93,7 +93,7 @@ func (c *controller) syncAutoScaleConfigMap(component *v3.Component, app *v3.App
 			log.Infoln("Configmap adapter-config not found,then create it")
 			var stringmap map[string]string = make(map[string]string)
 			var config MetricsDiscoveryConfig
-			rule := generaterule(app.Name+"-"+component.Name+"-"+"workload", component.ComponentTraits.Autoscaling.Metric, component.Version)
+			rule := generaterule(app.Name+"-"+component.Name+"-workload-"+component.Version, component.ComponentTraits.Autoscaling.Metric, app.Namespace)
 			config.Rules = append(config.Rules, rule)
 			value, err := yaml.Marshal(config)
 			if err != nil {
@@ -103,6 +103,7 @@ func (c *controller) syncAutoScaleConfigMap(component *v3.Component, app *v3.App
 			object := NewAutoScaleConfigMapObject(component, app, stringmap)
 			log.Debugf("NewAutoScaleConfigMapObject %v", object)
 			newconfigmap, err := c.configmapClient.Create(&object)
+			c.recordEvent(app, ActionCreate, "ConfigMap", object.Name, err)
 			if err != nil {
 				log.Errorf("Create configmap for %s Error : %s\n", "adapter-config", err.Error())
 				return err
@@ -172,10 +173,19 @@ func (c *controller) syncAutoScaleConfigMap(component *v3.Component, app *v3.App
 	configmap.Data["config.yaml"] = string(value)
 	configmap.ResourceVersion = ""
 	newcm, err := c.configmapClient.Update(configmap)
+	c.recordEvent(app, ActionUpdate, "ConfigMap", configmap.Name, err)
 	if err != nil {
 		log.Errorf("Update configmap for %s Error : %s\n", (app.Namespace + ":" + app.Name + ":" + component.Name), err.Error())
 		return err
 	}
+	c.reloadAdapter()
+
+	log.Debugf("Update hpaconfigmap adapter-config %v", newcm)
+	return nil
+}
+
+// reloadAdapter restart prometheus-adapter so that it load the updated adapter-config
+func (c *controller) reloadAdapter() {
 	log.Infoln("HPA ConfigMap updated, prometheus-adapter pod need update config too")
 	pods, err := c.podLister.List("monitoring", labels.Everything())
 	if err != nil {
@@ -191,9 +201,6 @@ func (c *controller) syncAutoScaleConfigMap(component *v3.Component, app *v3.App
 			log.Errorf("Delete %s pod %s failed", i.Namespace, i.Name)
 		}
 	}
-
-	log.Debugf("Update hpaconfigmap adapter-config %v", newcm)
-	return nil
 }
 
 // syncAutoScale use for syncAutoScale
@@ -202,38 +209,18 @@ func (c *controller) syncAutoScale(component *v3.Component, app *v3.Application,
 		log.Infof("This app don't need to configure autoscale for %s", app.Namespace+":"+app.Name+"-"+component.Name)
 		return nil
 	}
-	if component.ComponentTraits.Autoscaling.MaxReplicas < component.ComponentTraits.Autoscaling.MinReplicas {
+	log.Infof("Sync autoscale for %s", app.Namespace+":"+app.Name+"-"+component.Name)
+	object, err := renderAutoScale(component, app, ref)
+	if err != nil {
 		log.Errorf("这个服务自动扩缩配置的最大副本数小于最小副本数 配置无效 %s", app.Namespace+":"+app.Name+"-"+component.Name)
-		return nil
+		return err
 	}
-	log.Infof("Sync autoscale for %s", app.Namespace+":"+app.Name+"-"+component.Name)
-	insObject := NewAutoScaleInstance(component, app, ref)
-	log.Debugf("AutoScaleObject %v", insObject)
-	//zk
-	insObjectString := GetObjectApplied(insObject)
-	insObject.Annotations = make(map[string]string)
-	insObject.Annotations[LastAppliedConfigAnnotation] = insObjectString
-	instance, err := c.autoscaleLister.Get(app.Namespace, app.Name+"-"+component.Name+"-"+component.Version+"-hpa")
+	log.Debugf("AutoScaleObject %v", object)
+	_, _, err = c.apply(app, object)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			_, err = c.autoscaleClient.Create(&insObject)
-			if err != nil {
-				log.Errorf("Create autoscale for %s error : %s\n", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
-				return nil
-			}
-		}
-	} else {
-		if instance != nil {
-			if instance.Annotations[LastAppliedConfigAnnotation] != insObjectString {
-				insObject.ObjectMeta.ResourceVersion = instance.ObjectMeta.ResourceVersion
-				_, err = c.autoscaleClient.Update(&insObject)
-				if err != nil {
-					log.Errorf("Update autoscale for %s error : %s\n", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
-				}
-			}
-		}
+		log.Errorf("Sync autoscale for %s error : %s\n", (app.Namespace + ":" + app.Name + "-" + component.Name), err.Error())
 	}
-	return nil
+	return err
 }
 
 // NewAutoScaleConfigMapObject Use for generate NewAutoScaleConfigMapObject
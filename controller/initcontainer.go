@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SettingInitContainers workload setting naming the containers of the component which run as init containers,
+// comma separated in the order they run. Each must exit successfully before the next one and the regular containers start.
+const SettingInitContainers = "initContainers"
+
+// initContainerNames the names listed by the initContainers setting of component, in order
+func initContainerNames(component *v3.Component) []string {
+	var names []string
+	for _, name := range strings.Split(workloadSetting(component, SettingInitContainers), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func isInitContainer(component *v3.Component, name string) bool {
+	return contains(initContainerNames(component), name)
+}
+
+// getInitContainers convert the init containers of component in the order of the initContainers setting,
+// with the same config, env, volume and resource handling as regular containers
+func getInitContainers(component *v3.Component) ([]corev1.Container, error) {
+	var containers []corev1.Container
+	for _, name := range initContainerNames(component) {
+		var cc *v3.ComponentContainer
+		for i := range component.Containers {
+			if component.Containers[i].Name == name {
+				cc = &component.Containers[i]
+				break
+			}
+		}
+		if cc == nil {
+			return nil, fmt.Errorf("init container %s of component %s is not one of its containers", name, component.Name)
+		}
+		// kubernetes rejects them on init containers, which run to completion
+		if cc.LivenessProbe != nil || cc.ReadinessProbe != nil || cc.Lifecycle != nil {
+			return nil, fmt.Errorf("init container %s of component %s can not have probes or lifecycle handlers", name, component.Name)
+		}
+		container, err := getContainer(component, *cc)
+		if err != nil {
+			return nil, err
+		}
+		containers = append(containers, container)
+	}
+	return containers, nil
+}
+
+// initContainerFailure describe an init container of pod which failed or is crash-looping, empty when none
+func initContainerFailure(pod *corev1.Pod) string {
+	var failing []string
+	for _, cs := range pod.Status.InitContainerStatuses {
+		switch {
+		case cs.State.Waiting != nil && crashReasons[cs.State.Waiting.Reason]:
+			failing = append(failing, fmt.Sprintf("%s/%s: Init:%s", pod.Name, cs.Name, cs.State.Waiting.Reason))
+		case cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0:
+			failing = append(failing, fmt.Sprintf("%s/%s: Init:%s, exit code %d", pod.Name, cs.Name, cs.State.Terminated.Reason, cs.State.Terminated.ExitCode))
+		}
+	}
+	return strings.Join(failing, "; ")
+}
@@ -0,0 +1,297 @@
+package controller
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"time"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Batch component workloadTypes, a Job runs once per spec, a CronJob runs a Job on schedule
+const (
+	WorkloadTypeJob     v3.WorkloadType = "Job"
+	WorkloadTypeCronJob v3.WorkloadType = "CronJob"
+)
+
+// Job and CronJob workload settings
+const (
+	// SettingSchedule cron schedule of a CronJob, required
+	SettingSchedule = "schedule"
+	// SettingConcurrencyPolicy Allow (default), Forbid or Replace
+	SettingConcurrencyPolicy = "concurrencyPolicy"
+	// SettingBackoffLimit retries before the job is marked failed
+	SettingBackoffLimit = "backoffLimit"
+	// SettingActiveDeadlineSeconds running time after which the job is terminated
+	SettingActiveDeadlineSeconds = "activeDeadlineSeconds"
+	// SettingSuccessfulJobsHistoryLimit finished jobs a CronJob keeps
+	SettingSuccessfulJobsHistoryLimit = "successfulJobsHistoryLimit"
+	// SettingFailedJobsHistoryLimit failed jobs a CronJob keeps
+	SettingFailedJobsHistoryLimit = "failedJobsHistoryLimit"
+	// SettingTTLSecondsAfterFinished finished jobs are deleted after this time, needs the TTLAfterFinished feature gate
+	SettingTTLSecondsAfterFinished = "ttlSecondsAfterFinished"
+	// SettingRestartPolicy OnFailure (default) or Never
+	SettingRestartPolicy = "restartPolicy"
+)
+
+// Results of a job run
+const (
+	JobRunRunning   = "Running"
+	JobRunSucceeded = "Succeeded"
+	JobRunFailed    = "Failed"
+)
+
+// JobRun is the last run of a Job or CronJob component, reported in its component status
+type JobRun struct {
+	Job            string `json:"job"`
+	Result         string `json:"result"`
+	StartTime      string `json:"startTime,omitempty"`
+	CompletionTime string `json:"completionTime,omitempty"`
+	Message        string `json:"message,omitempty"`
+	// Revision of the job spec the run belongs to, a Job removed by its ttl is not rerun for the same revision
+	Revision string `json:"revision,omitempty"`
+}
+
+// batchWorkload report whether the pods of component run to completion
+func batchWorkload(component *v3.Component) bool {
+	return component.WorkloadType == WorkloadTypeJob || component.WorkloadType == WorkloadTypeCronJob
+}
+
+// NewJobObject Use for generate JobObject, the pod template is the one of NewDeployObject, without sidecars.
+// The selector is generated by the apiserver.
+func NewJobObject(component *v3.Component, app *v3.Application) (batchv1.Job, error) {
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		return batchv1.Job{}, err
+	}
+	spec, err := newJobSpec(component, deploy.Spec.Template)
+	if err != nil {
+		return batchv1.Job{}, err
+	}
+	return batchv1.Job{
+		ObjectMeta: deploy.ObjectMeta,
+		Spec:       spec,
+	}, nil
+}
+
+// NewCronJobObject Use for generate CronJobObject, every scheduled job has the spec of NewJobObject
+func NewCronJobObject(component *v3.Component, app *v3.Application) (batchv1beta1.CronJob, error) {
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		return batchv1beta1.CronJob{}, err
+	}
+	spec, err := newJobSpec(component, deploy.Spec.Template)
+	if err != nil {
+		return batchv1beta1.CronJob{}, err
+	}
+	schedule := workloadSetting(component, SettingSchedule)
+	if schedule == "" {
+		return batchv1beta1.CronJob{}, fmt.Errorf("%s of cronjob component %s is required", SettingSchedule, component.Name)
+	}
+	cronjob := batchv1beta1.CronJob{
+		ObjectMeta: deploy.ObjectMeta,
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:          schedule,
+			ConcurrencyPolicy: batchv1beta1.AllowConcurrent,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				Spec: spec,
+			},
+		},
+	}
+	switch policy := workloadSetting(component, SettingConcurrencyPolicy); policy {
+	case "", string(batchv1beta1.AllowConcurrent):
+	case string(batchv1beta1.ForbidConcurrent), string(batchv1beta1.ReplaceConcurrent):
+		cronjob.Spec.ConcurrencyPolicy = batchv1beta1.ConcurrencyPolicy(policy)
+	default:
+		return batchv1beta1.CronJob{}, fmt.Errorf("%s %q of component %s is invalid, must be Allow, Forbid or Replace", SettingConcurrencyPolicy, policy, component.Name)
+	}
+	if cronjob.Spec.SuccessfulJobsHistoryLimit, err = int32Setting(component, SettingSuccessfulJobsHistoryLimit, 0); err != nil {
+		return batchv1beta1.CronJob{}, err
+	}
+	if cronjob.Spec.FailedJobsHistoryLimit, err = int32Setting(component, SettingFailedJobsHistoryLimit, 0); err != nil {
+		return batchv1beta1.CronJob{}, err
+	}
+	return cronjob, nil
+}
+
+// newJobSpec build the job spec around template, whose pods are restarted on failure and do not serve traffic
+func newJobSpec(component *v3.Component, template corev1.PodTemplateSpec) (batchv1.JobSpec, error) {
+	outOfPool(&template)
+	switch policy := workloadSetting(component, SettingRestartPolicy); policy {
+	case "", string(corev1.RestartPolicyOnFailure):
+		template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+	case string(corev1.RestartPolicyNever):
+		template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	default:
+		return batchv1.JobSpec{}, fmt.Errorf("%s %q of component %s is invalid, must be OnFailure or Never", SettingRestartPolicy, policy, component.Name)
+	}
+	spec := batchv1.JobSpec{Template: template}
+	var err error
+	if spec.BackoffLimit, err = int32Setting(component, SettingBackoffLimit, 0); err != nil {
+		return batchv1.JobSpec{}, err
+	}
+	if spec.TTLSecondsAfterFinished, err = int32Setting(component, SettingTTLSecondsAfterFinished, 0); err != nil {
+		return batchv1.JobSpec{}, err
+	}
+	deadline, err := int32Setting(component, SettingActiveDeadlineSeconds, 1)
+	if err != nil {
+		return batchv1.JobSpec{}, err
+	}
+	if deadline != nil {
+		seconds := int64(*deadline)
+		spec.ActiveDeadlineSeconds = &seconds
+	}
+	return spec, nil
+}
+
+// int32Setting parse the named workload setting, nil when it is not set
+func int32Setting(component *v3.Component, name string, min int64) (*int32, error) {
+	value := workloadSetting(component, name)
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || i < min {
+		return nil, fmt.Errorf("%s %q of component %s is invalid, must be an integer not less than %d", name, value, component.Name, min)
+	}
+	result := int32(i)
+	return &result, nil
+}
+
+// validSchedule is a light check of the cron format, five fields or a predefined schedule like @daily
+func validSchedule(schedule string) bool {
+	if strings.HasPrefix(schedule, "@") {
+		return len(schedule) > 1
+	}
+	return len(strings.Fields(schedule)) == 5
+}
+
+// jobRevision hash of the applied config of job, it changes whenever the rendered job changes
+func jobRevision(job metav1.Object) string {
+	h := fnv.New32a()
+	h.Write([]byte(job.GetAnnotations()[LastAppliedConfigAnnotation]))
+	return strconv.FormatUint(uint64(h.Sum32()), 16)
+}
+
+// applyJob create desired or update the live job. The pod template of a job is immutable, a job
+// whose spec changed is deleted and created again, which starts a new run.
+// A job whose run for the same revision finished and was removed by its ttl is not created again,
+// the result is nil then.
+func (c *controller) applyJob(app *v3.Application, key string, desired *batchv1.Job) (runtime.Object, error) {
+	live, err := c.jobLister.Get(desired.Namespace, desired.Name)
+	if errors.IsNotFound(err) {
+		old, err := c.getStatus(app)
+		if err != nil {
+			log.Errorf("Get status of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+		}
+		if run := old.ComponentResource[key].LastRun; run != nil && run.Job == desired.Name && run.Result != JobRunRunning && run.Revision == jobRevision(desired) {
+			log.Debugf("Job %s finished and was removed, not run again", desired.Namespace+":"+desired.Name)
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	} else if live.Annotations[LastAppliedConfigAnnotation] != desired.Annotations[LastAppliedConfigAnnotation] {
+		deletePolicy := metav1.DeletePropagationBackground
+		err = c.jobClient.DeleteNamespaced(live.Namespace, live.Name, &metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+			Preconditions:     &metav1.Preconditions{UID: &live.UID},
+		})
+		c.recordEvent(app, ActionDelete, JobKind.Kind, live.Name, err)
+		if err != nil && !errors.IsNotFound(err) {
+			return nil, err
+		}
+		// the deletion is asynchronous, the job informer enqueues the application again once the job
+		// is gone and that sync creates the new one
+		log.Infof("Job %s spec changed, deleted and created again once it is gone", live.Namespace+":"+live.Name)
+		return live, nil
+	}
+	object, _, err := c.apply(app, desired)
+	return object, err
+}
+
+// jobRolloutStatus a running job is progressing, a failed one is stuck
+func jobRolloutStatus(job *batchv1.Job) (rolling, stuck bool, reason, message string) {
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			return false, false, "Completed", ""
+		case batchv1.JobFailed:
+			return false, true, cond.Reason, fmt.Sprintf("job %s failed: %s", job.Name, cond.Message)
+		}
+	}
+	return true, false, "Running", fmt.Sprintf("%d active, %d succeeded, %d failed pods", job.Status.Active, job.Status.Succeeded, job.Status.Failed)
+}
+
+// newJobRun result of the run of job
+func newJobRun(job *batchv1.Job) *JobRun {
+	run := &JobRun{
+		Job:    job.Name,
+		Result: JobRunRunning,
+	}
+	if job.Status.StartTime != nil {
+		run.StartTime = job.Status.StartTime.UTC().Format(time.RFC3339)
+	}
+	if job.Status.CompletionTime != nil {
+		run.CompletionTime = job.Status.CompletionTime.UTC().Format(time.RFC3339)
+	}
+	if _, ok := job.Annotations[LastAppliedConfigAnnotation]; ok {
+		run.Revision = jobRevision(job)
+	}
+	for _, cond := range job.Status.Conditions {
+		if cond.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch cond.Type {
+		case batchv1.JobComplete:
+			run.Result = JobRunSucceeded
+		case batchv1.JobFailed:
+			run.Result = JobRunFailed
+			run.Message = cond.Reason + ": " + cond.Message
+			if run.CompletionTime == "" {
+				run.CompletionTime = cond.LastTransitionTime.UTC().Format(time.RFC3339)
+			}
+		}
+	}
+	return run
+}
+
+// lastRun the run of a Job component, or of the newest job a CronJob component started,
+// nil for other workloads and when no job exists
+func (c *controller) lastRun(workload metav1.Object) *JobRun {
+	switch workload := workload.(type) {
+	case *batchv1.Job:
+		return newJobRun(workload)
+	case *batchv1beta1.CronJob:
+		jobs, err := c.jobLister.List(workload.Namespace, labels.Everything())
+		if err != nil {
+			log.Errorf("Get jobs of %s failed, err: %s", workload.Namespace+":"+workload.Name, err.Error())
+			return nil
+		}
+		var last *batchv1.Job
+		for _, job := range jobs {
+			if ref := metav1.GetControllerOf(job); ref == nil || ref.UID != workload.UID {
+				continue
+			}
+			if last == nil || last.CreationTimestamp.Before(&job.CreationTimestamp) {
+				last = job
+			}
+		}
+		if last != nil {
+			return newJobRun(last)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// observedCache remember generation and owned object versions of the last successful sync of each application,
+// an application whose spec and owned objects are both unchanged does not need to go through the pipeline again.
+type observedCache struct {
+	sync.Mutex
+	items map[string]string
+}
+
+func (o *observedCache) unchanged(key string, generation int64, fingerprint string) bool {
+	o.Lock()
+	defer o.Unlock()
+	observed, ok := o.items[key]
+	return ok && observed == fmt.Sprintf("%d/%s", generation, fingerprint)
+}
+
+// synced report whether the application was synced successfully at generation,
+// objects missing since then were deleted out of band
+func (o *observedCache) synced(key string, generation int64) bool {
+	o.Lock()
+	defer o.Unlock()
+	observed, ok := o.items[key]
+	return ok && strings.HasPrefix(observed, fmt.Sprintf("%d/", generation))
+}
+
+func (o *observedCache) set(key string, generation int64, fingerprint string) {
+	o.Lock()
+	defer o.Unlock()
+	if o.items == nil {
+		o.items = make(map[string]string)
+	}
+	o.items[key] = fmt.Sprintf("%d/%s", generation, fingerprint)
+}
+
+func (o *observedCache) delete(key string) {
+	o.Lock()
+	defer o.Unlock()
+	delete(o.items, key)
+}
+
+// ownedFingerprint join the resourceVersion of every object the pipeline owns for app,
+// read from the listers so that it costs no apiserver request.
+// adapter-config is shared by all applications and not part of it.
+func (c *controller) ownedFingerprint(app *v3.Application) string {
+	var versions []string
+	add := func(kind, name string, object metav1.Object, err error) {
+		version := "-"
+		if err == nil {
+			version = object.GetResourceVersion()
+		}
+		versions = append(versions, kind+"/"+name+"="+version)
+	}
+	addDeployment := func(name string) {
+		kind, object, err := c.getWorkload(app.Namespace, name)
+		if kind == "" {
+			kind = "Workload"
+		}
+		add(kind, name, object, err)
+	}
+
+	for _, component := range app.Spec.Components {
+		if len(component.Containers) == 0 {
+			addDeployment(component.Name)
+			continue
+		}
+		prefix := app.Name + "-" + component.Name + "-"
+		addDeployment(prefix + "workload" + "-" + component.Version)
+		if component.WorkloadType == WorkloadTypeStatefulSet {
+			service, err := c.serviceLister.Get(app.Namespace, prefix+component.Version+"-"+"headless")
+			add("Service", prefix+component.Version+"-"+"headless", service, err)
+		}
+		claims, _ := getClaims(&component, app)
+		for _, claim := range claims {
+			live, err := c.claimLister.Get(app.Namespace, claim.Name)
+			add("PersistentVolumeClaim", claim.Name, live, err)
+		}
+		// only the existence of referenced objects is reported
+		for _, ref := range componentReferences(&component) {
+			found, _ := c.referenceExists(app.Namespace, ref)
+			versions = append(versions, "Reference/"+ref+"="+strconv.FormatBool(found))
+		}
+		configmap, err := c.configmapLister.Get(app.Namespace, prefix+component.Version+"-"+"configmap")
+		add("ConfigMap", prefix+component.Version+"-"+"configmap", configmap, err)
+		secret, err := c.secretLister.Get(app.Namespace, configSecretName(&component, app))
+		add("Secret", configSecretName(&component, app), secret, err)
+		if component.ComponentTraits.Autoscaling != nil {
+			hpa, err := c.autoscaleLister.Get(app.Namespace, prefix+component.Version+"-hpa")
+			add("HorizontalPodAutoscaler", prefix+component.Version+"-hpa", hpa, err)
+		}
+	}
+	// versions waiting for gc
+	for _, resource := range app.Status.ComponentResource {
+		if resource.Workload != "" {
+			addDeployment(resource.Workload)
+		}
+	}
+
+	if app.Spec.OptTraits.ImagePullConfig != nil {
+		secret, err := c.secretLister.Get(app.Namespace, imagePullSecretName(app))
+		add("Secret", imagePullSecretName(app), secret, err)
+	}
+	service, err := c.serviceLister.Get(app.Namespace, app.Name+"-"+"service")
+	add("Service", app.Name+"-"+"service", service, err)
+	serviceRole, err := c.serviceRoleLister.Get(app.Namespace, app.Name+"-"+"servicerole")
+	add("ServiceRole", app.Name+"-"+"servicerole", serviceRole, err)
+	serviceRoleBinding, err := c.serviceRoleBindingLister.Get(app.Namespace, app.Name+"-"+"servicerolebinding")
+	add("ServiceRoleBinding", app.Name+"-"+"servicerolebinding", serviceRoleBinding, err)
+	vs, err := c.virtualServiceLister.Get(app.Namespace, app.Name+"-"+"vs")
+	add("VirtualService", app.Name+"-"+"vs", vs, err)
+	dest, err := c.destLister.Get(app.Namespace, app.Name+"-"+"destinationrule")
+	add("DestinationRule", app.Name+"-"+"destinationrule", dest, err)
+	if app.Spec.OptTraits.RateLimit != nil {
+		instance, err := c.instanceLister.Get(app.Namespace, app.Name+"-"+"quotainstance")
+		add("Instance", app.Name+"-"+"quotainstance", instance, err)
+		spec, err := c.quotaspecLister.Get(app.Namespace, app.Name+"-"+"quotaspec")
+		add("QuotaSpec", app.Name+"-"+"quotaspec", spec, err)
+		binding, err := c.quotaspecbindingLister.Get(app.Namespace, app.Name+"-"+"quotaspecbinding")
+		add("QuotaSpecBinding", app.Name+"-"+"quotaspecbinding", binding, err)
+		handler, err := c.handerLister.Get(app.Namespace, app.Name+"-"+"quotahandler")
+		add("Handler", app.Name+"-"+"quotahandler", handler, err)
+		rule, err := c.ruleLister.Get(app.Namespace, app.Name+"-"+"quotarule")
+		add("Rule", app.Name+"-"+"quotarule", rule, err)
+	}
+	if version := SidecarVersion(); version != "" {
+		versions = append(versions, "SidecarRegistry="+version)
+	}
+	sort.Strings(versions)
+	return strings.Join(versions, ",")
+}
+
+// statusRequests remember the applications enqueued only because the status of an owned workload changed,
+// their sync recomputes the status without applying anything. Any other enqueue of the key asks for a full sync
+// until it is taken.
+type statusRequests struct {
+	sync.Mutex
+	items map[string]bool
+}
+
+// request record why key is enqueued, a full sync is never downgraded to a status only one
+func (r *statusRequests) request(key string, statusOnly bool) {
+	r.Lock()
+	defer r.Unlock()
+	if r.items == nil {
+		r.items = make(map[string]bool)
+	}
+	if pending, ok := r.items[key]; ok && !pending {
+		return
+	}
+	r.items[key] = statusOnly
+}
+
+// take report whether key was enqueued for its status only and forget it,
+// keys enqueued by the application informer or a retry are not recorded and get a full sync
+func (r *statusRequests) take(key string) bool {
+	r.Lock()
+	defer r.Unlock()
+	statusOnly, ok := r.items[key]
+	delete(r.items, key)
+	return ok && statusOnly
+}
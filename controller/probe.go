@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// SettingStartupSeconds workload setting giving the containers of the component time to start before the liveness
+// checks begin. Kubernetes 1.12 has no startupProbe, the liveness initialDelaySeconds is raised to this value instead.
+const SettingStartupSeconds = "startupSeconds"
+
+// getProbe convert probe, nil when it has no usable handler
+func getProbe(probe *v3.HealthProbe) (*corev1.Probe, error) {
+	if probe == nil {
+		return nil, nil
+	}
+	handler, err := getHandler(&probe.Handler)
+	if err != nil || handler == nil {
+		return nil, err
+	}
+	return &corev1.Probe{
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		SuccessThreshold:    probe.SuccessThreshold,
+		FailureThreshold:    probe.FailureThreshold,
+		Handler:             *handler,
+	}, nil
+}
+
+// getHandler convert the first usable action of exec, httpGet and tcpSocket, nil when there is none
+func getHandler(handler *v3.Handler) (*corev1.Handler, error) {
+	if handler.Exec != nil {
+		if len(handler.Exec.Command) == 0 {
+			return nil, nil
+		}
+		command, err := getCommand(handler.Exec.Command)
+		if err != nil {
+			return nil, fmt.Errorf("exec command %s", err.Error())
+		}
+		return &corev1.Handler{Exec: &corev1.ExecAction{Command: command}}, nil
+	}
+	if handler.HTTPGet != nil {
+		action, err := getHTTPGetAction(handler.HTTPGet)
+		if err != nil {
+			return nil, fmt.Errorf("httpGet %s", err.Error())
+		}
+		if action == nil {
+			return nil, nil
+		}
+		return &corev1.Handler{HTTPGet: action}, nil
+	}
+	if handler.TCPSocket != nil && handler.TCPSocket.Port > 0 {
+		return &corev1.Handler{
+			TCPSocket: &corev1.TCPSocketAction{
+				Port: intstr.FromInt(handler.TCPSocket.Port),
+			},
+		}, nil
+	}
+	return nil, nil
+}
+
+// errHTTPGetNoPort the httpGet action has neither port nor a port in the url form of path
+var errHTTPGetNoPort = fmt.Errorf("has no port, set port or give it in the url form of path")
+
+// getHTTPGetAction path is either a plain path or an url scheme://host:port/path, whose scheme may be
+// HTTP or HTTPS, host defaults to the pod ip and port, a number or a container port name, replaces port.
+// A container port name can only be given by the url form, port is a number.
+func getHTTPGetAction(get *v3.HTTPGetAction) (*corev1.HTTPGetAction, error) {
+	if get.Path == "" {
+		return nil, nil
+	}
+	action := &corev1.HTTPGetAction{Path: get.Path}
+	if get.Port > 0 {
+		action.Port = intstr.FromInt(get.Port)
+	}
+	if scheme, rest, ok := splitScheme(get.Path); ok {
+		action.Scheme = scheme
+		authority, path := rest, "/"
+		if i := strings.Index(rest, "/"); i >= 0 {
+			authority, path = rest[:i], rest[i:]
+		}
+		action.Path = path
+		if i := strings.LastIndex(authority, ":"); i >= 0 {
+			port := authority[i+1:]
+			authority = authority[:i]
+			if number, err := strconv.Atoi(port); err == nil {
+				action.Port = intstr.FromInt(number)
+			} else {
+				action.Port = intstr.FromString(port)
+			}
+		}
+		action.Host = authority
+	}
+	if action.Port.Type == intstr.Int && action.Port.IntVal == 0 {
+		return nil, errHTTPGetNoPort
+	}
+	for _, header := range get.HTTPHeaders {
+		action.HTTPHeaders = append(action.HTTPHeaders, corev1.HTTPHeader{Name: header.Name, Value: header.Value})
+	}
+	return action, nil
+}
+
+// splitScheme split the url scheme of path off, case insensitive
+func splitScheme(path string) (corev1.URIScheme, string, bool) {
+	for _, scheme := range []corev1.URIScheme{corev1.URISchemeHTTP, corev1.URISchemeHTTPS} {
+		prefix := string(scheme) + "://"
+		if len(path) >= len(prefix) && strings.EqualFold(path[:len(prefix)], prefix) {
+			return scheme, path[len(prefix):], true
+		}
+	}
+	return "", "", false
+}
+
+// startupSeconds value of the startupSeconds setting, 0 when not set
+func startupSeconds(component *v3.Component) (int32, error) {
+	value := workloadSetting(component, SettingStartupSeconds)
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("%s %q of component %s is invalid, must be a non-negative number of seconds", SettingStartupSeconds, value, component.Name)
+	}
+	return int32(seconds), nil
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func TestGetHTTPGetAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		get     v3.HTTPGetAction
+		want    *corev1.HTTPGetAction
+		wantErr error
+	}{
+		{name: "no path", get: v3.HTTPGetAction{Port: 8080}, want: nil},
+		{name: "plain path", get: v3.HTTPGetAction{Path: "/healthz", Port: 8080},
+			want: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(8080)}},
+		{name: "plain path without port", get: v3.HTTPGetAction{Path: "/healthz"}, wantErr: errHTTPGetNoPort},
+		{name: "url", get: v3.HTTPGetAction{Path: "http://:8080/healthz"},
+			want: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTP, Path: "/healthz", Port: intstr.FromInt(8080)}},
+		{name: "url port replaces port", get: v3.HTTPGetAction{Path: "HTTPS://:8443/healthz", Port: 8080},
+			want: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTPS, Path: "/healthz", Port: intstr.FromInt(8443)}},
+		{name: "url named port", get: v3.HTTPGetAction{Path: "http://:metrics/metrics"},
+			want: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTP, Path: "/metrics", Port: intstr.FromString("metrics")}},
+		{name: "url host", get: v3.HTTPGetAction{Path: "https://example.com:443"},
+			want: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTPS, Host: "example.com", Path: "/", Port: intstr.FromInt(443)}},
+		{name: "url without port uses port", get: v3.HTTPGetAction{Path: "http://localhost/ready", Port: 9090},
+			want: &corev1.HTTPGetAction{Scheme: corev1.URISchemeHTTP, Host: "localhost", Path: "/ready", Port: intstr.FromInt(9090)}},
+		{name: "url without any port", get: v3.HTTPGetAction{Path: "http://localhost/ready"}, wantErr: errHTTPGetNoPort},
+		{name: "headers", get: v3.HTTPGetAction{Path: "/healthz", Port: 80, HTTPHeaders: []v3.HTTPHeader{{Name: "Host", Value: "web"}}},
+			want: &corev1.HTTPGetAction{Path: "/healthz", Port: intstr.FromInt(80), HTTPHeaders: []corev1.HTTPHeader{{Name: "Host", Value: "web"}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getHTTPGetAction(&tt.get)
+			if err != tt.wantErr {
+				t.Fatalf("getHTTPGetAction(%+v) error = %v, want %v", tt.get, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHTTPGetAction(%+v) = %+v, want %+v", tt.get, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler v3.Handler
+		want    *corev1.Handler
+		wantErr bool
+	}{
+		{name: "none", handler: v3.Handler{}, want: nil},
+		{name: "exec space split", handler: v3.Handler{Exec: &v3.ExecAction{Command: []string{"cat /tmp/ready"}}},
+			want: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"cat", "/tmp/ready"}}}},
+		{name: "exec verbatim", handler: v3.Handler{Exec: &v3.ExecAction{Command: []string{"sh", "-c", "verbatim:curl -f localhost"}}},
+			want: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "curl -f localhost"}}}},
+		{name: "exec shell", handler: v3.Handler{Exec: &v3.ExecAction{Command: []string{"shell:sh -c 'curl -f localhost'"}}},
+			want: &corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"sh", "-c", "curl -f localhost"}}}},
+		{name: "exec empty", handler: v3.Handler{Exec: &v3.ExecAction{}}, want: nil},
+		{name: "httpGet without port", handler: v3.Handler{HTTPGet: &v3.HTTPGetAction{Path: "/healthz"}}, wantErr: true},
+		{name: "tcpSocket", handler: v3.Handler{TCPSocket: &v3.TCPSocketAction{Port: 3306}},
+			want: &corev1.Handler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(3306)}}},
+		{name: "tcpSocket without port", handler: v3.Handler{TCPSocket: &v3.TCPSocketAction{}}, want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getHandler(&tt.handler)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getHandler error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHandler = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
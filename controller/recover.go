@@ -0,0 +1,97 @@
+package controller
+
+import (
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ConditionFailed the reconcile of the application panicked, set until a sync succeeds
+const ConditionFailed ConditionType = "Failed"
+
+// stackFrames how many frames of the panicking goroutine are kept in the condition message
+const stackFrames = 5
+
+// recovered wrap the application handler, a panic while reconciling one application is
+// turned into an error so the key is retried with backoff and the other applications keep going
+func (c *controller) recovered(handler func(key string, app *v3.Application) (runtime.Object, error)) func(key string, app *v3.Application) (runtime.Object, error) {
+	return func(key string, app *v3.Application) (object runtime.Object, err error) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			stack := debug.Stack()
+			log.Errorf("Sync application %s panic: %v\n%s", key, r, stack)
+			err = fmt.Errorf("sync application %s panic: %v", key, r)
+			c.observed.delete(key)
+			if app != nil {
+				c.markFailed(app, "Panic", fmt.Sprintf("panic: %v; %s", r, stackSummary(stack)))
+			}
+		}()
+		return handler(key, app)
+	}
+}
+
+// markFailed write the Failed condition into the status of app and keep everything else
+func (c *controller) markFailed(app *v3.Application, reason, message string) {
+	old, err := c.getStatus(app)
+	if err != nil {
+		log.Errorf("Get status of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+	}
+	status := ApplicationStatus{
+		ObservedGeneration: old.ObservedGeneration,
+		ComponentResource:  old.ComponentResource,
+	}
+	for _, cond := range old.Conditions {
+		if cond.Type != ConditionFailed {
+			status.Conditions = append(status.Conditions, cond)
+		}
+	}
+	status.Conditions = append(status.Conditions, mergeConditions(old.Conditions, []Condition{newCondition(ConditionFailed, true, reason, message)}, time.Now().UTC().Format(time.RFC3339))...)
+	// keys of app.Status not in status would be removed
+	failed := app.DeepCopy()
+	failed.Status.ComponentResource = nil
+	err = c.writeStatus(failed, old, status)
+	if err != nil {
+		log.Errorf("Update status of application %s Error : %s", (app.Namespace + ":" + app.Name), err.Error())
+	}
+	if c.recorder != nil {
+		c.recorder.Event(app, corev1.EventTypeWarning, reason, message)
+	}
+}
+
+// stackSummary keep the first frames after the panic call, as "function file:line"
+func stackSummary(stack []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(stack)), "\n")
+	// the first line is the goroutine header, then function and file lines alternate
+	start := 1
+	for i := 1; i+1 < len(lines); i += 2 {
+		if strings.HasPrefix(lines[i], "panic(") {
+			start = i + 2
+			break
+		}
+	}
+	var frames []string
+	for i := start; i+1 < len(lines) && len(frames) < stackFrames; i += 2 {
+		function := lines[i]
+		if index := strings.LastIndex(function, "("); index > 0 {
+			function = function[:index]
+		}
+		location := strings.TrimSpace(lines[i+1])
+		if index := strings.LastIndex(location, " +0x"); index > 0 {
+			location = location[:index]
+		}
+		if index := strings.LastIndex(location, "/"); index > 0 {
+			location = location[index+1:]
+		}
+		frames = append(frames, function+" "+location)
+	}
+	return strings.Join(frames, " < ")
+}
@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	istiorbacv1alpha1 "github.com/hd-Li/types/pkg/istio/apis/rbac/v1alpha1"
+	istiov1alpha3 "github.com/knative/pkg/apis/istio/v1alpha3"
+	"github.com/rancher/norman/types"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	"k8s.io/api/autoscaling/v2beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Object kinds rendered from an application
+var (
+	ConfigMapKind               = corev1.SchemeGroupVersion.WithKind("ConfigMap")
+	SecretKind                  = corev1.SchemeGroupVersion.WithKind("Secret")
+	PersistentVolumeClaimKind   = corev1.SchemeGroupVersion.WithKind("PersistentVolumeClaim")
+	DeploymentKind              = appsv1beta2.SchemeGroupVersion.WithKind("Deployment")
+	StatefulSetKind             = appsv1beta2.SchemeGroupVersion.WithKind("StatefulSet")
+	DaemonSetKind               = appsv1beta2.SchemeGroupVersion.WithKind("DaemonSet")
+	JobKind                     = batchv1.SchemeGroupVersion.WithKind("Job")
+	CronJobKind                 = batchv1beta1.SchemeGroupVersion.WithKind("CronJob")
+	HorizontalPodAutoscalerKind = schema.GroupVersionKind{Group: "autoscaling", Version: "v2beta2", Kind: "HorizontalPodAutoscaler"}
+	ServiceKind                 = corev1.SchemeGroupVersion.WithKind("Service")
+	ServiceRoleKind             = schema.GroupVersionKind{Group: "rbac.istio.io", Version: "v1alpha1", Kind: "ServiceRole"}
+	ServiceRoleBindingKind      = schema.GroupVersionKind{Group: "rbac.istio.io", Version: "v1alpha1", Kind: "ServiceRoleBinding"}
+	VirtualServiceKind          = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "VirtualService"}
+	DestinationRuleKind         = schema.GroupVersionKind{Group: "networking.istio.io", Version: "v1alpha3", Kind: "DestinationRule"}
+	InstanceKind                = schema.GroupVersionKind{Group: "config.istio.io", Version: "v1alpha2", Kind: "instance"}
+	QuotaSpecKind               = schema.GroupVersionKind{Group: "config.istio.io", Version: "v1alpha2", Kind: "QuotaSpec"}
+	QuotaSpecBindingKind        = schema.GroupVersionKind{Group: "config.istio.io", Version: "v1alpha2", Kind: "QuotaSpecBinding"}
+	HandlerKind                 = schema.GroupVersionKind{Group: "config.istio.io", Version: "v1alpha2", Kind: "handler"}
+	RuleKind                    = schema.GroupVersionKind{Group: "config.istio.io", Version: "v1alpha2", Kind: "rule"}
+)
+
+// Render generate every object the controller would submit for app, without any cluster access.
+// Defaults are applied to a copy of app, as the controller does. A panic of a generator is returned as error.
+// Objects carry the same LastAppliedConfigAnnotation as the synced ones.
+// Owner references to objects created in the cluster (the hpa target deployment) have no uid.
+// DaemonSets, Jobs and CronJobs have no hpa, an application made only of them has no traffic objects.
+// Shared objects (adapter-config, gateway, policy, clusterrbacconfig) are not part of the result.
+func Render(app *v3.Application) (objects []runtime.Object, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			objects, err = nil, fmt.Errorf("render application %s panic: %v; %s", app.Namespace+":"+app.Name, r, stackSummary(debug.Stack()))
+		}
+	}()
+	return render(app)
+}
+
+// render is Render without the recover, a panic of a generator reaches the caller
+func render(app *v3.Application) (objects []runtime.Object, err error) {
+	app = app.DeepCopy()
+	SetDefaults(app)
+	var errs []error
+	// shared claims are rendered once
+	claims := make(map[string]bool)
+	secret, err := renderImagePullSecret(app)
+	if err != nil {
+		errs = append(errs, err)
+	} else if secret != nil {
+		objects = append(objects, secret)
+	}
+
+	for i := range app.Spec.Components {
+		component := &app.Spec.Components[i]
+		if len(component.Containers) == 0 {
+			// trusted workload, not managed by the controller
+			continue
+		}
+		if configmap := renderConfigMap(component, app); configmap != nil {
+			objects = append(objects, configmap)
+		}
+		if secret := renderConfigSecret(component, app); secret != nil {
+			objects = append(objects, secret)
+		}
+		rendered, err := renderClaims(component, app)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, claim := range rendered {
+			if !claims[claim.Name] {
+				claims[claim.Name] = true
+				objects = append(objects, claim)
+			}
+		}
+		workload, err := renderWorkload(component, app)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		objects = append(objects, workload)
+		ref := metav1.NewControllerRef(workload.(metav1.Object), workload.GetObjectKind().GroupVersionKind())
+		objects = append(objects, renderWorkloadOwned(component, app, ref)...)
+		if component.ComponentTraits.Autoscaling != nil && scalable(component.WorkloadType) {
+			hpa, err := renderAutoScale(component, app, ref)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				objects = append(objects, hpa)
+			}
+		}
+	}
+
+	if !servesTraffic(app) {
+		return objects, types.NewErrors(errs...)
+	}
+	objects = append(objects, renderService(app), renderServiceRole(app), renderVirtualService(app), renderDestinationRule(app))
+	if binding := renderServiceRoleBinding(app); binding != nil {
+		objects = append(objects, binding)
+	}
+	if app.Spec.OptTraits.RateLimit != nil {
+		objects = append(objects, renderQuota(app)...)
+	}
+	return objects, types.NewErrors(errs...)
+}
+
+// renderImagePullSecret return nil when the application has no imagePullConfig
+func renderImagePullSecret(app *v3.Application) (*corev1.Secret, error) {
+	if app.Spec.OptTraits.ImagePullConfig == nil {
+		return nil, nil
+	}
+	object, err := NewSecretObject(app)
+	if err != nil {
+		return nil, err
+	}
+	return withAppliedSecret(&object), nil
+}
+
+// renderConfigMap return nil when the component has no config file
+func renderConfigMap(component *v3.Component, app *v3.Application) *corev1.ConfigMap {
+	object := NewConfigMapObject(component, app)
+	if len(object.Data) == 0 {
+		return nil
+	}
+	return withApplied(&object, ConfigMapKind).(*corev1.ConfigMap)
+}
+
+// renderConfigSecret return nil when the component has no sensitive config file
+func renderConfigSecret(component *v3.Component, app *v3.Application) *corev1.Secret {
+	object := NewConfigSecretObject(component, app)
+	if len(object.Data) == 0 {
+		return nil
+	}
+	return withAppliedSecret(&object)
+}
+
+// renderClaims render the claims mounted by the pods of component
+func renderClaims(component *v3.Component, app *v3.Application) ([]*corev1.PersistentVolumeClaim, error) {
+	claims, err := getClaims(component, app)
+	if err != nil {
+		return nil, err
+	}
+	var objects []*corev1.PersistentVolumeClaim
+	for i := range claims {
+		objects = append(objects, withApplied(&claims[i], PersistentVolumeClaimKind).(*corev1.PersistentVolumeClaim))
+	}
+	return objects, nil
+}
+
+// renderWorkload render the workload of component according to its workloadType
+func renderWorkload(component *v3.Component, app *v3.Application) (runtime.Object, error) {
+	switch component.WorkloadType {
+	case WorkloadTypeStatefulSet:
+		return renderStatefulSet(component, app)
+	case WorkloadTypeDaemonSet:
+		return renderDaemonSet(component, app)
+	case WorkloadTypeJob:
+		return renderJob(component, app)
+	case WorkloadTypeCronJob:
+		return renderCronJob(component, app)
+	}
+	return renderDeployment(component, app)
+}
+
+// renderWorkloadOwned render the objects owned by the workload, ref is the controller reference of the workload
+func renderWorkloadOwned(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) []runtime.Object {
+	switch component.WorkloadType {
+	case WorkloadTypeStatefulSet:
+		service := NewHeadlessServiceObject(component, app, ref)
+		return []runtime.Object{withApplied(&service, ServiceKind)}
+	}
+	return nil
+}
+
+func renderDeployment(component *v3.Component, app *v3.Application) (*appsv1beta2.Deployment, error) {
+	object, err := NewDeployObject(component, app)
+	if err != nil {
+		return nil, err
+	}
+	if component.ComponentTraits.Autoscaling != nil {
+		// replicas is owned by the hpa, leaving it out keeps apply from resetting it
+		object.Spec.Replicas = nil
+	}
+	return withApplied(&object, DeploymentKind).(*appsv1beta2.Deployment), nil
+}
+
+func renderStatefulSet(component *v3.Component, app *v3.Application) (*appsv1beta2.StatefulSet, error) {
+	object, err := NewStatefulSetObject(component, app)
+	if err != nil {
+		return nil, err
+	}
+	if component.ComponentTraits.Autoscaling != nil {
+		object.Spec.Replicas = nil
+	}
+	return withApplied(&object, StatefulSetKind).(*appsv1beta2.StatefulSet), nil
+}
+
+func renderDaemonSet(component *v3.Component, app *v3.Application) (*appsv1beta2.DaemonSet, error) {
+	object, err := NewDaemonSetObject(component, app)
+	if err != nil {
+		return nil, err
+	}
+	return withApplied(&object, DaemonSetKind).(*appsv1beta2.DaemonSet), nil
+}
+
+func renderJob(component *v3.Component, app *v3.Application) (*batchv1.Job, error) {
+	object, err := NewJobObject(component, app)
+	if err != nil {
+		return nil, err
+	}
+	return withApplied(&object, JobKind).(*batchv1.Job), nil
+}
+
+func renderCronJob(component *v3.Component, app *v3.Application) (*batchv1beta1.CronJob, error) {
+	object, err := NewCronJobObject(component, app)
+	if err != nil {
+		return nil, err
+	}
+	return withApplied(&object, CronJobKind).(*batchv1beta1.CronJob), nil
+}
+
+// renderAutoScale ref is the controller reference of the target workload
+func renderAutoScale(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) (*v2beta2.HorizontalPodAutoscaler, error) {
+	autoscaling := component.ComponentTraits.Autoscaling
+	if autoscaling.MaxReplicas < autoscaling.MinReplicas {
+		return nil, fmt.Errorf("autoscaling of %s is invalid: maxReplicas %d is less than minReplicas %d", component.Name, autoscaling.MaxReplicas, autoscaling.MinReplicas)
+	}
+	object := NewAutoScaleInstance(component, app, ref)
+	return withApplied(&object, HorizontalPodAutoscalerKind).(*v2beta2.HorizontalPodAutoscaler), nil
+}
+
+func renderService(app *v3.Application) *corev1.Service {
+	object := NewServiceObject(app)
+	return withApplied(&object, ServiceKind).(*corev1.Service)
+}
+
+func renderServiceRole(app *v3.Application) *istiorbacv1alpha1.ServiceRole {
+	object := NewServiceRoleObject(app)
+	return withApplied(&object, ServiceRoleKind).(*istiorbacv1alpha1.ServiceRole)
+}
+
+func renderVirtualService(app *v3.Application) *istiov1alpha3.VirtualService {
+	object := NewVirtualServiceObject(app)
+	return withApplied(&object, VirtualServiceKind).(*istiov1alpha3.VirtualService)
+}
+
+func renderDestinationRule(app *v3.Application) *istiov1alpha3.DestinationRule {
+	object := NewDestinationruleObject(app)
+	return withApplied(&object, DestinationRuleKind).(*istiov1alpha3.DestinationRule)
+}
+
+// renderServiceRoleBinding return nil when whitelist has no user
+func renderServiceRoleBinding(app *v3.Application) *istiorbacv1alpha1.ServiceRoleBinding {
+	if app.Spec.OptTraits.WhiteList == nil || len(app.Spec.OptTraits.WhiteList.Users) == 0 {
+		return nil
+	}
+	object := NewServiceRoleBinding(app)
+	return withApplied(&object, ServiceRoleBindingKind).(*istiorbacv1alpha1.ServiceRoleBinding)
+}
+
+// renderQuota return instance, quotaspec, quotaspecbinding, handler and rule of the rate limit
+func renderQuota(app *v3.Application) []runtime.Object {
+	instance := NewQuotaInstance(app)
+	spec := NewQuotaSpec(app)
+	binding := NewQuotaSpecBinding(app)
+	handler := NewQuotaHandlerObject(app)
+	rule := NewQuotaRuleObject(app)
+	return []runtime.Object{
+		withApplied(&instance, InstanceKind),
+		withApplied(&spec, QuotaSpecKind),
+		withApplied(&binding, QuotaSpecBindingKind),
+		withApplied(handler, HandlerKind),
+		withApplied(&rule, RuleKind),
+	}
+}
+
+// withApplied fill apiVersion and kind, and record the object itself in LastAppliedConfigAnnotation
+// which is the original of the next three-way merge
+func withApplied(object runtime.Object, gvk schema.GroupVersionKind) runtime.Object {
+	object.GetObjectKind().SetGroupVersionKind(gvk)
+	accessor := object.(metav1.Object)
+	// a copy, the map may be shared with the application or another object
+	annotations := copyStringMap(accessor.GetAnnotations())
+	delete(annotations, LastAppliedConfigAnnotation)
+	accessor.SetAnnotations(annotations)
+	applied := GetObjectApplied(object)
+	annotations[LastAppliedConfigAnnotation] = applied
+	accessor.SetAnnotations(annotations)
+	return object
+}
+
+// withAppliedSecret is withApplied leaving the values out of LastAppliedConfigAnnotation, credentials are not
+// readable from the annotation. Only the key names are recorded, so a removed key is deleted by the next patch,
+// and the data is recorded as ChecksumAnnotation, which changes with it.
+func withAppliedSecret(secret *corev1.Secret) *corev1.Secret {
+	if secret.Annotations == nil {
+		secret.Annotations = make(map[string]string)
+	}
+	secret.Annotations[ChecksumAnnotation] = GetDataChecksum(secret.Data)
+	applied := secret.DeepCopy()
+	applied.Data = make(map[string][]byte, len(secret.Data))
+	for k := range secret.Data {
+		applied.Data[k] = []byte{}
+	}
+	withApplied(applied, SecretKind)
+	secret.SetGroupVersionKind(SecretKind)
+	secret.Annotations[LastAppliedConfigAnnotation] = applied.Annotations[LastAppliedConfigAnnotation]
+	return secret
+}
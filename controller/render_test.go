@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"fmt"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+)
+
+// renderPool values the fuzzed strings are drawn from, so that a share of the applications pass validation
+var renderPool = []string{"", "web", "v1", "v1.0.12", "a", "80", "100m", "1Gi", "/data", "app.conf", "cpu",
+	"Always", "IfNotPresent", "sh -c 'sleep 5'", "verbatim:a b", "shell:a 'b c'", "metadata.name",
+	"configMapKeyRef:cm/key", "secretKeyRef:s/key", "sensitive", "http://:8080/healthz", "1 \"", "-1", "x_y"}
+
+func newRenderFuzzer(seed int64) *fuzz.Fuzzer {
+	return fuzz.NewWithSeed(seed).NilChance(0.3).NumElements(0, 3).Funcs(
+		func(s *string, c fuzz.Continue) {
+			*s = renderPool[c.Intn(len(renderPool))]
+		},
+		func(i *int, c fuzz.Continue) {
+			*i = c.Intn(10) - 1
+		},
+		func(i *int32, c fuzz.Continue) {
+			*i = int32(c.Intn(10) - 1)
+		},
+	)
+}
+
+// renderNoPanic call render, which does not recover, so a panic of a generator fails the test
+// whether the application is valid or not
+func renderNoPanic(app *v3.Application) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	_, renderErr := render(app)
+	if renderErr != nil && len(ValidateApplication(app)) == 0 {
+		return fmt.Errorf("valid application failed to render: %s", renderErr.Error())
+	}
+	return nil
+}
+
+// TestRenderFuzz the generators never panic, and an application which passes ValidateApplication renders without error
+func TestRenderFuzz(t *testing.T) {
+	for _, seed := range []int64{1, 2, 3, 4, 5} {
+		f := newRenderFuzzer(seed)
+		valid := 0
+		for i := 0; i < 1000; i++ {
+			app := new(v3.Application)
+			f.Fuzz(app)
+			app.Name, app.Namespace = "demo", "team"
+			if len(ValidateApplication(app)) == 0 {
+				valid++
+			}
+			if err := renderNoPanic(app); err != nil {
+				t.Fatalf("seed %d iteration %d: %s\n%+v", seed, i, err.Error(), app.Spec)
+			}
+		}
+		if valid == 0 {
+			t.Errorf("seed %d: no fuzzed application passed validation", seed)
+		}
+	}
+}
@@ -102,8 +102,8 @@ func NewVirtualServiceObject(app *v3.Application) istiov1alpha3.VirtualService {
 		}
 	} else {
 		httproute.Retries = &istiov1alpha3.HTTPRetry{
-			Attempts:      3,
-			PerTryTimeout: "10s",
+			Attempts:      DefaultRetryAttempts,
+			PerTryTimeout: DefaultRetryPerTryTimeout,
 			RetryOn:       "5xx,gateway-error,connect-failure,refused-stream",
 		}
 	}
@@ -188,7 +188,7 @@ func NewDestinationruleObject(app *v3.Application) istiov1alpha3.DestinationRule
 			trafficPolicy.LoadBalancer = lbsetting
 		}
 	} else {
-		trafficPolicy.LoadBalancer = &istiov1alpha3.LoadBalancerSettings{Simple: istiov1alpha3.SimpleLBRoundRobin}
+		trafficPolicy.LoadBalancer = &istiov1alpha3.LoadBalancerSettings{Simple: istiov1alpha3.SimpleLB(DefaultLoadBalancer)}
 	}
 	if app.Spec.OptTraits.CircuitBreaking != nil {
 		if app.Spec.OptTraits.CircuitBreaking.ConnectionPool != nil {
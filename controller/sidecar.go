@@ -0,0 +1,327 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/ghodss/yaml"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Built-in sidecars, enabled by the customMetric and logcollect component traits.
+// A registry entry of the same name replaces them.
+const (
+	SidecarMetricProxy = "metric-proxy"
+	SidecarLogCollect  = "log-collect"
+)
+
+// SettingSidecars workload setting naming the registry sidecars injected into the pods of the component, comma separated
+const SettingSidecars = "sidecars"
+
+// SidecarTemplate is one entry of the sidecar registry. Each entry of the registry ConfigMap holds it as yaml,
+// rendered as a text/template with the fields of SidecarValues first. The container name defaults to the entry name.
+type SidecarTemplate struct {
+	Container corev1.Container `json:"container"`
+	Volumes   []corev1.Volume  `json:"volumes,omitempty"`
+}
+
+// SidecarValues is the data a sidecar template is rendered with
+type SidecarValues struct {
+	App       string
+	Namespace string
+	Component string
+	Version   string
+	// Values defaults of the component parameters and the trait values, uri of customMetric
+	Values map[string]string
+}
+
+// sidecarRegistry hold the templates of the registry ConfigMap, read by every render
+type sidecarRegistry struct {
+	sync.RWMutex
+	templates map[string]*template.Template
+	version   string
+}
+
+var sidecars = &sidecarRegistry{}
+
+// SetSidecarTemplates replace the registry with data, entry name to template. version identifies the content,
+// applications are synced again when it changes. Entries which do not parse are skipped and returned as error.
+func SetSidecarTemplates(data map[string]string, version string) error {
+	templates := make(map[string]*template.Template)
+	var errs []string
+	for name, text := range data {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("sidecar %s: %s", name, err.Error()))
+			continue
+		}
+		templates[name] = t
+	}
+	sidecars.Lock()
+	sidecars.templates = templates
+	sidecars.version = version
+	sidecars.Unlock()
+	if len(errs) != 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// SidecarVersion version of the loaded registry, part of the owned fingerprint of applications
+func SidecarVersion() string {
+	sidecars.RLock()
+	defer sidecars.RUnlock()
+	return sidecars.version
+}
+
+func lookupSidecar(name string) *template.Template {
+	sidecars.RLock()
+	defer sidecars.RUnlock()
+	return sidecars.templates[name]
+}
+
+// componentSidecars names of the sidecars of component in injection order, the trait ones first.
+// Jobs and CronJobs have none, a long running sidecar would keep their pods from completing.
+func componentSidecars(component *v3.Component) []string {
+	var names []string
+	if batchWorkload(component) {
+		return names
+	}
+	if metric := component.ComponentTraits.CustomMetric; metric != nil && metric.Enable && metric.Uri != "" {
+		names = append(names, SidecarMetricProxy)
+	}
+	if component.ComponentTraits.Logcollect {
+		names = append(names, SidecarLogCollect)
+	}
+	for _, name := range strings.Split(workloadSetting(component, SettingSidecars), ",") {
+		if name = strings.TrimSpace(name); name != "" && !contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// getSidecars render the sidecar containers of component and the pod volumes they need, each sidecar once per pod
+func getSidecars(component *v3.Component, app *v3.Application) ([]corev1.Container, []corev1.Volume, error) {
+	values := SidecarValues{
+		App:       app.Name,
+		Namespace: app.Namespace,
+		Component: component.Name,
+		Version:   component.Version,
+		Values:    make(map[string]string),
+	}
+	for _, parameter := range component.Parameters {
+		values.Values[parameter.Name] = parameter.Default
+	}
+	if metric := component.ComponentTraits.CustomMetric; metric != nil {
+		values.Values["uri"] = metric.Uri
+	}
+
+	var containers []corev1.Container
+	var volumes []corev1.Volume
+	for _, name := range componentSidecars(component) {
+		sidecar, err := renderSidecar(name, values)
+		if err != nil {
+			return nil, nil, fmt.Errorf("sidecar %s of component %s: %s", name, component.Name, err.Error())
+		}
+		if sidecar == nil {
+			log.Debugf("Sidecar %s of component %s is not configured, skip it", name, component.Name)
+			continue
+		}
+		if contains(containerNames(component), sidecar.Container.Name) {
+			return nil, nil, fmt.Errorf("sidecar %s of component %s has the name of one of its containers", sidecar.Container.Name, component.Name)
+		}
+		containers = append(containers, sidecar.Container)
+		volumes = append(volumes, sidecar.Volumes...)
+	}
+	return containers, volumes, nil
+}
+
+// renderSidecar render the registry entry name, or the built-in sidecar when the registry has none.
+// nil when neither exists for a trait sidecar, an unknown sidecar of the sidecars setting is an error.
+func renderSidecar(name string, values SidecarValues) (*SidecarTemplate, error) {
+	t := lookupSidecar(name)
+	if t == nil {
+		switch name {
+		case SidecarMetricProxy:
+			return metricProxySidecar(values), nil
+		case SidecarLogCollect:
+			return logCollectSidecar(values), nil
+		}
+		return nil, fmt.Errorf("not found in the sidecar registry")
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, values); err != nil {
+		return nil, err
+	}
+	sidecar := new(SidecarTemplate)
+	if err := yaml.Unmarshal(buf.Bytes(), sidecar); err != nil {
+		return nil, err
+	}
+	if sidecar.Container.Name == "" {
+		sidecar.Container.Name = name
+	}
+	if sidecar.Container.Image == "" {
+		return nil, fmt.Errorf("image is required")
+	}
+	return sidecar, nil
+}
+
+// metricProxySidecar expose the custom metric uri of the pod to prometheus, image from PROXYIMAGE
+func metricProxySidecar(values SidecarValues) *SidecarTemplate {
+	resources := map[corev1.ResourceName]resource.Quantity{
+		corev1.ResourceCPU:    resource.MustParse("50m"),
+		corev1.ResourceMemory: resource.MustParse("50Mi"),
+	}
+	return &SidecarTemplate{
+		Container: corev1.Container{
+			Name:            "transter-proxy",
+			Image:           os.Getenv("PROXYIMAGE"),
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Resources: corev1.ResourceRequirements{
+				Limits:   resources,
+				Requests: resources,
+			},
+			Env: []corev1.EnvVar{
+				{
+					Name: "POD_NAME",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{
+							APIVersion: "v1",
+							FieldPath:  "metadata.name",
+						}},
+				},
+				{
+					Name: "POD_NAMESPACE",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{
+							APIVersion: "v1",
+							FieldPath:  "metadata.namespace",
+						}},
+				},
+				{
+					Name: "POD_IP",
+					ValueFrom: &corev1.EnvVarSource{
+						FieldRef: &corev1.ObjectFieldSelector{
+							APIVersion: "v1",
+							FieldPath:  "status.podIP",
+						}},
+				},
+				{
+					Name:  "URI",
+					Value: values.Values["uri"],
+				},
+			},
+		},
+	}
+}
+
+// logCollectSidecar ship the logs under /log with fluentd, image from LOGIMAGE and
+// config from the LOGCOLLECT_CONFIGMAP_NAME configmap, nil when that is not set
+func logCollectSidecar(values SidecarValues) *SidecarTemplate {
+	if os.Getenv("LOGCOLLECT_CONFIGMAP_NAME") == "" {
+		return nil
+	}
+	resources := map[corev1.ResourceName]resource.Quantity{
+		corev1.ResourceCPU:    resource.MustParse("100m"),
+		corev1.ResourceMemory: resource.MustParse("200Mi"),
+	}
+	return &SidecarTemplate{
+		Container: corev1.Container{
+			Name:            "custom-log-collect",
+			Image:           os.Getenv("LOGIMAGE"),
+			ImagePullPolicy: corev1.PullIfNotPresent,
+			Env: []corev1.EnvVar{
+				{
+					Name:  "FLUENTD_ARGS",
+					Value: "--no-supervisor -q",
+				},
+			},
+			Resources: corev1.ResourceRequirements{
+				Limits:   resources,
+				Requests: resources,
+			},
+			VolumeMounts: []corev1.VolumeMount{
+				{
+					Name:      values.Component + "-" + "logdir",
+					MountPath: "/log",
+				},
+				{
+					Name:      values.Component + "-" + "log" + "-" + "configmap",
+					MountPath: "/fluentd/etc/",
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			{
+				Name:         values.Component + "-" + "logdir",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			},
+			{
+				Name: values.Component + "-" + "log" + "-" + "configmap",
+				VolumeSource: corev1.VolumeSource{
+					ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{
+							Name: os.Getenv("LOGCOLLECT_CONFIGMAP_NAME"),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// watchSidecars keep the registry in sync with the ConfigMap SIDECAR_CONFIGMAP_NAMESPACE/SIDECAR_CONFIGMAP_NAME
+// and sync every application again when it changes, without it only the built-in sidecars exist
+func (c *controller) watchSidecars(enqueue func(namespace, name string)) {
+	namespace, name := os.Getenv("SIDECAR_CONFIGMAP_NAMESPACE"), os.Getenv("SIDECAR_CONFIGMAP_NAME")
+	if namespace == "" || name == "" {
+		log.Infoln("SIDECAR_CONFIGMAP_NAMESPACE or SIDECAR_CONFIGMAP_NAME not set, only the built-in sidecars are available")
+		return
+	}
+	reload := func(obj interface{}, deleted bool) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		configmap, ok := obj.(*corev1.ConfigMap)
+		if !ok || configmap.Namespace != namespace || configmap.Name != name {
+			return
+		}
+		var data map[string]string
+		version := ""
+		if !deleted {
+			data, version = configmap.Data, configmap.ResourceVersion
+		}
+		if version == SidecarVersion() {
+			return
+		}
+		if err := SetSidecarTemplates(data, version); err != nil {
+			log.Errorf("Load sidecar registry %s Error : %s", namespace+":"+name, err.Error())
+		}
+		log.Infof("Sidecar registry %s reloaded, %d templates", namespace+":"+name, len(data))
+		apps, err := c.applicationLister.List("", labels.Everything())
+		if err != nil {
+			log.Errorf("List applications for sidecar registry %s Error : %s", namespace+":"+name, err.Error())
+			return
+		}
+		for _, app := range apps {
+			enqueue(app.Namespace, app.Name)
+		}
+	}
+	c.configmapClient.Controller().Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { reload(obj, false) },
+		UpdateFunc: func(old, obj interface{}) { reload(obj, false) },
+		DeleteFunc: func(obj interface{}) { reload(obj, true) },
+	})
+}
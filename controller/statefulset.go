@@ -0,0 +1,192 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// WorkloadTypeStatefulSet component workloadType rendered as StatefulSet, the v3 workload types are all Deployments
+const WorkloadTypeStatefulSet v3.WorkloadType = "StatefulSet"
+
+// StatefulSet workload settings
+const (
+	// SettingPodManagementPolicy OrderedReady (default) or Parallel
+	SettingPodManagementPolicy = "podManagementPolicy"
+	// SettingPartition pods with an ordinal lower than partition keep the old version during a rolling update
+	SettingPartition = "partition"
+)
+
+// NewStatefulSetObject Use for generate StatefulSetObject, the pod template is the one of
+// NewDeployObject, exclusive volumes which are not ephemeral become volumeClaimTemplates, one claim per pod
+func NewStatefulSetObject(component *v3.Component, app *v3.Application) (appsv1beta2.StatefulSet, error) {
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		return appsv1beta2.StatefulSet{}, err
+	}
+	claims, err := getVolumeClaims(component, app)
+	if err != nil {
+		return appsv1beta2.StatefulSet{}, err
+	}
+	template := deploy.Spec.Template
+	var volumes []corev1.Volume
+	for _, volume := range template.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && claimed(claims, volume.Name) {
+			continue
+		}
+		volumes = append(volumes, volume)
+	}
+	template.Spec.Volumes = volumes
+
+	statefulset := appsv1beta2.StatefulSet{
+		ObjectMeta: deploy.ObjectMeta,
+		Spec: appsv1beta2.StatefulSetSpec{
+			Replicas:             deploy.Spec.Replicas,
+			Selector:             deploy.Spec.Selector,
+			Template:             template,
+			ServiceName:          headlessServiceName(component, app),
+			VolumeClaimTemplates: claims,
+			PodManagementPolicy:  appsv1beta2.OrderedReadyPodManagement,
+			UpdateStrategy: appsv1beta2.StatefulSetUpdateStrategy{
+				Type: appsv1beta2.RollingUpdateStatefulSetStrategyType,
+			},
+		},
+	}
+	switch policy := workloadSetting(component, SettingPodManagementPolicy); policy {
+	case "", string(appsv1beta2.OrderedReadyPodManagement):
+	case string(appsv1beta2.ParallelPodManagement):
+		statefulset.Spec.PodManagementPolicy = appsv1beta2.ParallelPodManagement
+	default:
+		return appsv1beta2.StatefulSet{}, fmt.Errorf("%s %q of component %s is invalid, must be OrderedReady or Parallel", SettingPodManagementPolicy, policy, component.Name)
+	}
+	if value := workloadSetting(component, SettingPartition); value != "" {
+		partition, err := strconv.ParseInt(value, 10, 32)
+		if err != nil || partition < 0 {
+			return appsv1beta2.StatefulSet{}, fmt.Errorf("%s %q of component %s is invalid, must be a non-negative integer", SettingPartition, value, component.Name)
+		}
+		p := int32(partition)
+		statefulset.Spec.UpdateStrategy.RollingUpdate = &appsv1beta2.RollingUpdateStatefulSetStrategy{Partition: &p}
+	}
+	return statefulset, nil
+}
+
+// NewHeadlessServiceObject Use for generate the governing service of a statefulset, ref is the statefulset
+func NewHeadlessServiceObject(component *v3.Component, app *v3.Application, ref *metav1.OwnerReference) corev1.Service {
+	var ports []corev1.ServicePort
+	for _, container := range component.Containers {
+		if isInitContainer(component, container.Name) {
+			continue
+		}
+		for _, port := range getContainerPorts(container) {
+			ports = append(ports, corev1.ServicePort{
+				Name:       port.Name,
+				Port:       port.ContainerPort,
+				TargetPort: intstr.FromInt(int(port.ContainerPort)),
+				Protocol:   port.Protocol,
+			})
+		}
+	}
+	// names are required when there are several ports
+	if len(ports) > 1 {
+		for i := range ports {
+			if ports[i].Name == "" {
+				ports[i].Name = "port" + "-" + strconv.Itoa(int(ports[i].Port))
+			}
+		}
+	}
+	return corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{*ref},
+			Namespace:       app.Namespace,
+			Name:            headlessServiceName(component, app),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				"app":     app.Name + "-" + "workload",
+				"version": component.Version,
+			},
+			Ports:                    ports,
+			PublishNotReadyAddresses: true,
+		},
+	}
+}
+
+func headlessServiceName(component *v3.Component, app *v3.Application) string {
+	return app.Name + "-" + component.Name + "-" + component.Version + "-" + "headless"
+}
+
+// getVolumeClaims build a claim template for every exclusive volume which is not ephemeral.
+// The template has the name of the volume mount so the containers mount it unchanged,
+// shared volumes are mounted from the claim of the application.
+func getVolumeClaims(component *v3.Component, app *v3.Application) ([]corev1.PersistentVolumeClaim, error) {
+	var claims []corev1.PersistentVolumeClaim
+	for _, container := range component.Containers {
+		for _, volume := range container.Resources.Volumes {
+			if !persistent(volume) || volume.SharingPolicy == SharingPolicyShared {
+				continue
+			}
+			name := component.Name + "-" + volume.Name
+			if claimed(claims, name) {
+				// mounted by several containers
+				continue
+			}
+			spec, err := newClaimSpec(app, volume)
+			if err != nil {
+				return nil, err
+			}
+			claims = append(claims, corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: name},
+				Spec:       spec,
+			})
+		}
+	}
+	return claims, nil
+}
+
+// workloadSetting value of the named workloadSetings entry, fromParam refers to the default of a component parameter
+func workloadSetting(component *v3.Component, name string) string {
+	for _, setting := range component.WorkloadSettings {
+		if setting.Name != name {
+			continue
+		}
+		if setting.Value != "" || setting.FromParam == "" {
+			return setting.Value
+		}
+		for _, parameter := range component.Parameters {
+			if parameter.Name == setting.FromParam {
+				return parameter.Default
+			}
+		}
+	}
+	return ""
+}
+
+// statefulSetRolloutStatus is the same check kubectl rollout status does
+func statefulSetRolloutStatus(statefulset *appsv1beta2.StatefulSet) (rolling bool, reason, message string) {
+	if statefulset.Generation > statefulset.Status.ObservedGeneration {
+		return true, "RollingOut", "waiting for statefulset spec update to be observed"
+	}
+	replicas := int32(1)
+	if statefulset.Spec.Replicas != nil {
+		replicas = *statefulset.Spec.Replicas
+	}
+	if statefulset.Status.ReadyReplicas < replicas {
+		return true, "RollingOut", fmt.Sprintf("%d of %d pods are ready", statefulset.Status.ReadyReplicas, replicas)
+	}
+	if rollingUpdate := statefulset.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.Partition != nil {
+		if statefulset.Status.UpdatedReplicas < replicas-*rollingUpdate.Partition {
+			return true, "RollingOut", fmt.Sprintf("%d of %d pods above partition %d have been updated", statefulset.Status.UpdatedReplicas, replicas-*rollingUpdate.Partition, *rollingUpdate.Partition)
+		}
+		return false, "PartitionRolledOut", ""
+	}
+	if statefulset.Status.UpdateRevision != statefulset.Status.CurrentRevision {
+		return true, "RollingOut", fmt.Sprintf("%d of %d pods have been updated", statefulset.Status.UpdatedReplicas, replicas)
+	}
+	return false, "RolloutComplete", ""
+}
@@ -0,0 +1,497 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	normantypes "github.com/rancher/norman/types"
+	log "github.com/sirupsen/logrus"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// ConditionType define condition types of application and component
+type ConditionType string
+
+// Condition types
+const (
+	// ConditionReady all pods of the workload are ready and traffic is configured
+	ConditionReady ConditionType = "Ready"
+	// ConditionProgressing the workload is rolling out
+	ConditionProgressing ConditionType = "Progressing"
+	// ConditionDegraded a sync step failed, pods are crash-looping or the rollout is stuck
+	ConditionDegraded ConditionType = "Degraded"
+	// ConditionTrafficConfigured service, virtualservice, destinationrule, rbac and quota are synced
+	ConditionTrafficConfigured ConditionType = "TrafficConfigured"
+	// ConditionAutoscalingConfigured hpa and adapter-config are synced
+	ConditionAutoscalingConfigured ConditionType = "AutoscalingConfigured"
+	// ConditionReferencesResolved the configmaps and secrets read by the env and config files of the containers exist, a warning only
+	ConditionReferencesResolved ConditionType = "ReferencesResolved"
+)
+
+// Condition is a kubernetes style status condition
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	LastTransitionTime string                 `json:"lastTransitionTime,omitempty"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+}
+
+// ApplicationStatus is the status maintained by this controller.
+// v3.ApplicationStatus only knows componentResource, the other fields are kept by
+// the apiserver because the application crd has no structural schema, so status is
+// always written with a merge patch instead of Update.
+type ApplicationStatus struct {
+	ObservedGeneration int64                      `json:"observedGeneration,omitempty"`
+	Conditions         []Condition                `json:"conditions,omitempty"`
+	ComponentResource  map[string]ComponentStatus `json:"componentResource,omitempty"`
+}
+
+// ComponentStatus extends v3.ComponentResources with conditions of one component version,
+// Job and CronJob components also report their last run
+type ComponentStatus struct {
+	v3.ComponentResources
+	Conditions []Condition `json:"conditions,omitempty"`
+	LastRun    *JobRun     `json:"lastRun,omitempty"`
+}
+
+// crashReasons container waiting reasons treat as degraded
+var crashReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// statusBuilder collect the result of every sync step during one reconcile
+type statusBuilder struct {
+	componentErrs map[string][]error
+	autoscaling   map[string]error
+	missingRefs   map[string][]string
+	unsupported   map[string][]string
+	trafficErrs   []error
+	errs          []error
+}
+
+func newStatusBuilder() *statusBuilder {
+	return &statusBuilder{
+		componentErrs: make(map[string][]error),
+		autoscaling:   make(map[string]error),
+		missingRefs:   make(map[string][]string),
+		unsupported:   make(map[string][]string),
+	}
+}
+
+// component record result of a workload/configmap step of component version key
+func (b *statusBuilder) component(key string, err error) {
+	if err != nil {
+		b.componentErrs[key] = append(b.componentErrs[key], err)
+		b.errs = append(b.errs, err)
+	}
+}
+
+// autoscale record result of hpa step, only called for components with autoscaling
+func (b *statusBuilder) autoscale(key string, err error) {
+	b.autoscaling[key] = err
+	if err != nil {
+		b.errs = append(b.errs, err)
+	}
+}
+
+// traffic record result of service/author/policy step
+func (b *statusBuilder) traffic(err error) {
+	if err != nil {
+		b.trafficErrs = append(b.trafficErrs, err)
+		b.errs = append(b.errs, err)
+	}
+}
+
+// err aggregate all step errors of this reconcile, nil when every step succeeded
+// references record the configmaps and secrets of component key which are missing, nil when all exist,
+// and the env entries whose fromParam is not supported.
+// It does not fail the sync, the pods wait for them.
+func (b *statusBuilder) references(key string, missing, unsupported []string) {
+	b.missingRefs[key] = missing
+	b.unsupported[key] = unsupported
+}
+
+func (b *statusBuilder) err() error {
+	return normantypes.NewErrors(b.errs...)
+}
+
+// statusCache remember the last status written for each application,
+// so conditions keep their transition time without reading the object back every time.
+type statusCache struct {
+	sync.Mutex
+	items map[string]ApplicationStatus
+}
+
+func (s *statusCache) get(key string) (ApplicationStatus, bool) {
+	s.Lock()
+	defer s.Unlock()
+	status, ok := s.items[key]
+	return status, ok
+}
+
+func (s *statusCache) set(key string, status ApplicationStatus) {
+	s.Lock()
+	defer s.Unlock()
+	if s.items == nil {
+		s.items = make(map[string]ApplicationStatus)
+	}
+	s.items[key] = status
+}
+
+func (s *statusCache) delete(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.items, key)
+}
+
+// getStatus return the status last written to app, read from the apiserver on cache miss
+func (c *controller) getStatus(app *v3.Application) (ApplicationStatus, error) {
+	key := app.Namespace + "/" + app.Name
+	if status, ok := c.statuses.get(key); ok {
+		return status, nil
+	}
+	obj, err := c.applicationClient.ObjectClient().UnstructuredClient().GetNamespaced(app.Namespace, app.Name, metav1.GetOptions{})
+	if err != nil {
+		return ApplicationStatus{}, err
+	}
+	status, err := statusFromObject(obj)
+	if err != nil {
+		return status, err
+	}
+	c.statuses.set(key, status)
+	return status, nil
+}
+
+func statusFromObject(obj interface{}) (ApplicationStatus, error) {
+	var status ApplicationStatus
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return status, fmt.Errorf("unexpected object type %T", obj)
+	}
+	raw, ok := u.Object["status"]
+	if !ok {
+		return status, nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return status, err
+	}
+	err = json.Unmarshal(b, &status)
+	return status, err
+}
+
+// writeStatus merge patch status onto the status subresource of app, nothing is written
+// when status equals old. componentResource entries of versions which no longer exist
+// are removed explicitly, because merge patch merges maps.
+func (c *controller) writeStatus(app *v3.Application, old, status ApplicationStatus) error {
+	if statusEqual(old, status) {
+		log.Debugf("Status of application %s not changed", app.Namespace+":"+app.Name)
+		return nil
+	}
+	resources := make(map[string]interface{})
+	for k := range old.ComponentResource {
+		resources[k] = nil
+	}
+	for k := range app.Status.ComponentResource {
+		resources[k] = nil
+	}
+	for k, v := range status.ComponentResource {
+		resources[k] = v
+	}
+	patch := map[string]interface{}{
+		"status": map[string]interface{}{
+			"observedGeneration": status.ObservedGeneration,
+			"conditions":         status.Conditions,
+			"componentResource":  resources,
+		},
+	}
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	obj, err := c.applicationClient.ObjectClient().UnstructuredClient().Patch(app.Name, app, types.MergePatchType, data, "status")
+	if err != nil {
+		return err
+	}
+	written, err := statusFromObject(obj)
+	if err != nil {
+		return err
+	}
+	c.statuses.set(app.Namespace+"/"+app.Name, written)
+	return nil
+}
+
+// computeStatus build conditions of app and every component version from the owned
+// deployments, their pods and the result of each sync step
+func (c *controller) computeStatus(app *v3.Application, old ApplicationStatus, b *statusBuilder) ApplicationStatus {
+	now := time.Now().UTC().Format(time.RFC3339)
+	status := ApplicationStatus{
+		ObservedGeneration: app.Generation,
+		ComponentResource:  make(map[string]ComponentStatus),
+	}
+
+	var notReady, progressing, degraded, autoscalingFailed []string
+	for key, resource := range app.Status.ComponentResource {
+		cs := ComponentStatus{ComponentResources: resource}
+		var conds []Condition
+		if resource.Workload == "" {
+			// gc of this version failed, keep the key so that it is retried
+			status.ComponentResource[key] = cs
+			continue
+		}
+		_, workload, err := c.getWorkload(app.Namespace, resource.Workload)
+		if err != nil {
+			workload = nil
+		}
+		ready, readyReason, readyMsg := false, "WorkloadNotFound", "workload "+resource.Workload+" not found"
+		rolling, rollReason, rollMsg := false, "WorkloadNotFound", readyMsg
+		crashed, crashMsg := false, ""
+		// the run is kept when the job is removed by its ttl or the cronjob history limit
+		cs.LastRun = old.ComponentResource[key].LastRun
+		if workload != nil {
+			if run := c.lastRun(workload); run != nil {
+				cs.LastRun = run
+			}
+		} else if run := cs.LastRun; run != nil && run.Job == resource.Workload && run.Result != JobRunRunning {
+			ready, readyReason, readyMsg = run.Result == JobRunSucceeded, "JobFinished", "job "+run.Job+" "+strings.ToLower(run.Result)+" and was removed"
+			rolling, rollReason, rollMsg = false, "JobFinished", ""
+		}
+		if run := cs.LastRun; run != nil && run.Result == JobRunFailed {
+			crashed, crashMsg = true, "job "+run.Job+" failed: "+run.Message
+		}
+		if workload != nil {
+			var stuck bool
+			var selector *metav1.LabelSelector
+			var desired int32
+			rolling, stuck, rollReason, rollMsg, selector, desired = workloadRollout(workload)
+			if stuck {
+				crashed, crashMsg = true, rollMsg
+			}
+			readyPods, total, msg := c.podsStatus(workload, selector)
+			if msg != "" {
+				crashed = true
+				crashMsg = msg
+			}
+			readyMsg = fmt.Sprintf("%d/%d pods ready, %d desired", readyPods, total, desired)
+			readyReason = "PodsNotReady"
+			if !rolling && !stuck && readyPods >= desired {
+				ready, readyReason = true, "PodsReady"
+			}
+		}
+		errs := b.componentErrs[key]
+		if len(errs) != 0 {
+			ready, readyReason, readyMsg = false, "SyncFailed", joinErrors(errs)
+		}
+		conds = append(conds, newCondition(ConditionReady, ready, readyReason, readyMsg))
+		conds = append(conds, newCondition(ConditionProgressing, rolling, rollReason, rollMsg))
+		switch {
+		case len(errs) != 0:
+			conds = append(conds, newCondition(ConditionDegraded, true, "SyncFailed", joinErrors(errs)))
+		case crashed:
+			conds = append(conds, newCondition(ConditionDegraded, true, "PodsFailing", crashMsg))
+		default:
+			conds = append(conds, newCondition(ConditionDegraded, false, "AsExpected", ""))
+		}
+		if err, ok := b.autoscaling[key]; ok {
+			if err != nil {
+				conds = append(conds, newCondition(ConditionAutoscalingConfigured, false, "SyncFailed", err.Error()))
+				autoscalingFailed = append(autoscalingFailed, key)
+			} else {
+				conds = append(conds, newCondition(ConditionAutoscalingConfigured, true, "Synced", ""))
+			}
+		}
+		if missing, ok := b.missingRefs[key]; ok {
+			var messages []string
+			if len(missing) != 0 {
+				messages = append(messages, strings.Join(missing, ",")+" not found in namespace "+app.Namespace)
+			}
+			if unsupported := b.unsupported[key]; len(unsupported) != 0 {
+				messages = append(messages, strings.Join(unsupported, ",")+" not supported, the value is used")
+			}
+			switch {
+			case len(missing) != 0:
+				conds = append(conds, newCondition(ConditionReferencesResolved, false, "ReferenceNotFound", strings.Join(messages, "; ")))
+			case len(messages) != 0:
+				conds = append(conds, newCondition(ConditionReferencesResolved, false, "UnsupportedSource", strings.Join(messages, "; ")))
+			default:
+				conds = append(conds, newCondition(ConditionReferencesResolved, true, "Resolved", ""))
+			}
+		}
+		cs.Conditions = mergeConditions(old.ComponentResource[key].Conditions, conds, now)
+		status.ComponentResource[key] = cs
+
+		if !ready {
+			notReady = append(notReady, key)
+		}
+		if rolling {
+			progressing = append(progressing, key)
+		}
+		if len(errs) != 0 || crashed {
+			degraded = append(degraded, key)
+		}
+	}
+
+	var conds []Condition
+	if len(b.trafficErrs) != 0 {
+		conds = append(conds, newCondition(ConditionTrafficConfigured, false, "SyncFailed", joinErrors(b.trafficErrs)))
+	} else {
+		conds = append(conds, newCondition(ConditionTrafficConfigured, true, "Synced", ""))
+	}
+	if len(notReady) == 0 && len(b.trafficErrs) == 0 {
+		conds = append(conds, newCondition(ConditionReady, true, "AllComponentsReady", ""))
+	} else if len(notReady) != 0 {
+		conds = append(conds, newCondition(ConditionReady, false, "ComponentsNotReady", strings.Join(notReady, ",")+" not ready"))
+	} else {
+		conds = append(conds, newCondition(ConditionReady, false, "TrafficNotConfigured", joinErrors(b.trafficErrs)))
+	}
+	if len(progressing) != 0 {
+		conds = append(conds, newCondition(ConditionProgressing, true, "RollingOut", strings.Join(progressing, ",")+" rolling out"))
+	} else {
+		conds = append(conds, newCondition(ConditionProgressing, false, "RolloutComplete", ""))
+	}
+	if len(degraded) != 0 || len(b.trafficErrs) != 0 {
+		msg := strings.Join(degraded, ",")
+		if len(b.trafficErrs) != 0 {
+			msg = strings.TrimPrefix(msg+","+joinErrors(b.trafficErrs), ",")
+		}
+		conds = append(conds, newCondition(ConditionDegraded, true, "ComponentsDegraded", msg))
+	} else {
+		conds = append(conds, newCondition(ConditionDegraded, false, "AsExpected", ""))
+	}
+	if len(b.autoscaling) != 0 {
+		if len(autoscalingFailed) != 0 {
+			conds = append(conds, newCondition(ConditionAutoscalingConfigured, false, "SyncFailed", strings.Join(autoscalingFailed, ",")))
+		} else {
+			conds = append(conds, newCondition(ConditionAutoscalingConfigured, true, "Synced", ""))
+		}
+	}
+	status.Conditions = mergeConditions(old.Conditions, conds, now)
+	return status
+}
+
+// deploymentRolloutStatus is the same check kubectl rollout status does
+func deploymentRolloutStatus(deploy *appsv1beta2.Deployment) (rolling, stuck bool, reason, message string) {
+	if deploy.Generation > deploy.Status.ObservedGeneration {
+		return true, false, "RollingOut", "waiting for deployment spec update to be observed"
+	}
+	for _, cond := range deploy.Status.Conditions {
+		if cond.Type == appsv1beta2.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			return true, true, "ProgressDeadlineExceeded", fmt.Sprintf("deployment %s exceeded its progress deadline", deploy.Name)
+		}
+	}
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	if deploy.Status.UpdatedReplicas < replicas {
+		return true, false, "RollingOut", fmt.Sprintf("%d out of %d new replicas have been updated", deploy.Status.UpdatedReplicas, replicas)
+	}
+	if deploy.Status.Replicas > deploy.Status.UpdatedReplicas {
+		return true, false, "RollingOut", fmt.Sprintf("%d old replicas are pending termination", deploy.Status.Replicas-deploy.Status.UpdatedReplicas)
+	}
+	if deploy.Status.AvailableReplicas < deploy.Status.UpdatedReplicas {
+		return true, false, "RollingOut", fmt.Sprintf("%d of %d updated replicas are available", deploy.Status.AvailableReplicas, deploy.Status.UpdatedReplicas)
+	}
+	return false, false, "RolloutComplete", ""
+}
+
+// podsStatus count ready pods of workload, message is not empty when some container is crash-looping
+// or an init container failed
+func (c *controller) podsStatus(workload metav1.Object, labelSelector *metav1.LabelSelector) (ready, total int32, message string) {
+	if labelSelector == nil {
+		return
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return
+	}
+	pods, err := c.podLister.List(workload.GetNamespace(), selector)
+	if err != nil {
+		log.Errorf("Get pods of %s failed, err: %s", workload.GetNamespace()+":"+workload.GetName(), err.Error())
+		return
+	}
+	var failing []string
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		total++
+		for _, cond := range pod.Status.Conditions {
+			if cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue {
+				ready++
+			}
+		}
+		if msg := initContainerFailure(pod); msg != "" {
+			failing = append(failing, msg)
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && crashReasons[cs.State.Waiting.Reason] {
+				failing = append(failing, fmt.Sprintf("%s/%s: %s", pod.Name, cs.Name, cs.State.Waiting.Reason))
+			}
+		}
+	}
+	message = strings.Join(failing, "; ")
+	return
+}
+
+// statusEqual compare the serialized form, old is read back from the apiserver
+// so nil and empty values must not make a difference
+func statusEqual(old, status ApplicationStatus) bool {
+	a, err := json.Marshal(old)
+	if err != nil {
+		return false
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return false
+	}
+	return string(a) == string(b)
+}
+
+func newCondition(t ConditionType, status bool, reason, message string) Condition {
+	cond := Condition{
+		Type:    t,
+		Status:  corev1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	}
+	if status {
+		cond.Status = corev1.ConditionTrue
+	}
+	return cond
+}
+
+// mergeConditions keep lastTransitionTime of conditions whose status did not change
+func mergeConditions(old, conds []Condition, now string) []Condition {
+	for i := range conds {
+		conds[i].LastTransitionTime = now
+		for _, o := range old {
+			if o.Type == conds[i].Type && o.Status == conds[i].Status && o.LastTransitionTime != "" {
+				conds[i].LastTransitionTime = o.LastTransitionTime
+			}
+		}
+	}
+	return conds
+}
+
+func joinErrors(errs []error) string {
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
@@ -0,0 +1,759 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AccessModes the accepted volume accessMode values, short forms included
+var AccessModes = []string{"", "RWO", "RWX", "ROX", "ReadWriteOnce", "ReadWriteMany", "ReadOnlyMany"}
+
+// SharingPolicies the accepted volume sharingPolicy values
+var SharingPolicies = []string{"", SharingPolicyExclusive, SharingPolicyShared}
+
+// PullPolicies the accepted container imagePullPolicy values, kubernetes decides when empty
+var PullPolicies = []string{"", string(v3.PullAlways), string(v3.PullIfNotPresent), string(v3.PullNever)}
+
+// ValidateApplication check the spec of app, every error carries the field path of the invalid value.
+// It covers the values the controller can not render: quantities, probe handlers, command quoting,
+// gray release weights, env sources, ingress and affinity requirements.
+func ValidateApplication(app *v3.Application) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	versions := make(map[string]bool)
+	for i := range app.Spec.Components {
+		component := &app.Spec.Components[i]
+		errs = append(errs, validateComponent(component, specPath.Child("components").Index(i))...)
+		errs = append(errs, validateObjectNames(app, component, specPath.Child("components").Index(i))...)
+		versions[component.Version] = true
+	}
+	errs = append(errs, validateSharedVolumes(app.Spec.Components, specPath.Child("components"))...)
+	errs = append(errs, validateGrayRelease(app.Spec.OptTraits.GrayRelease, versions, len(app.Spec.Components), specPath.Child("optTraits", "grayRelease"))...)
+	errs = append(errs, validateImagePullConfig(app.Spec.OptTraits.ImagePullConfig, specPath.Child("optTraits", "imagePullConfig"))...)
+	// an application made only of DaemonSets has no ingress
+	if servesTraffic(app) {
+		errs = append(errs, validateIngress(&app.Spec.OptTraits.Ingress, specPath.Child("optTraits", "ingress"))...)
+	}
+	return errs
+}
+
+// ValidateApplicationUpdate reject the changes of app the apiserver can not apply to the objects of old.
+// volumeClaimTemplates, podManagementPolicy, serviceName and selector of a StatefulSet are immutable,
+// they change with a new version of the component.
+func ValidateApplicationUpdate(app, old *v3.Application) field.ErrorList {
+	var errs field.ErrorList
+	for i := range app.Spec.Components {
+		component := &app.Spec.Components[i]
+		if component.WorkloadType != WorkloadTypeStatefulSet {
+			continue
+		}
+		for j := range old.Spec.Components {
+			oldComponent := &old.Spec.Components[j]
+			if oldComponent.Name != component.Name || oldComponent.Version != component.Version || oldComponent.WorkloadType != WorkloadTypeStatefulSet {
+				continue
+			}
+			errs = append(errs, validateStatefulSetUpdate(component, app, oldComponent, old, field.NewPath("spec", "components").Index(i))...)
+		}
+	}
+	return errs
+}
+
+func validateStatefulSetUpdate(component *v3.Component, app *v3.Application, oldComponent *v3.Component, old *v3.Application, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	statefulset, err := NewStatefulSetObject(component, app)
+	if err != nil {
+		// reported by ValidateApplication
+		return errs
+	}
+	oldStatefulset, err := NewStatefulSetObject(oldComponent, old)
+	if err != nil {
+		return errs
+	}
+	const msg = "can not be changed for an existing StatefulSet, deploy it as a new version"
+	if !apiequality.Semantic.DeepEqual(statefulset.Spec.VolumeClaimTemplates, oldStatefulset.Spec.VolumeClaimTemplates) {
+		errs = append(errs, field.Forbidden(path.Child("containers"), "volumes which are claimed per pod "+msg))
+	}
+	if statefulset.Spec.PodManagementPolicy != oldStatefulset.Spec.PodManagementPolicy {
+		errs = append(errs, field.Forbidden(path.Child("workloadSetings"), SettingPodManagementPolicy+" "+msg))
+	}
+	if statefulset.Spec.ServiceName != oldStatefulset.Spec.ServiceName || !apiequality.Semantic.DeepEqual(statefulset.Spec.Selector, oldStatefulset.Spec.Selector) {
+		errs = append(errs, field.Forbidden(path, "serviceName and selector "+msg))
+	}
+	return errs
+}
+
+// validateObjectNames the names of the objects generated for the component version must be valid,
+// they are DNS-1123 subdomains of at most 253 characters, the headless service of a StatefulSet is a DNS-1035 label
+func validateObjectNames(app *v3.Application, component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if component.Name == "" || component.Version == "" {
+		return errs
+	}
+	prefix := app.Name + "-" + component.Name + "-"
+	names := []string{
+		prefix + "workload" + "-" + component.Version,
+		prefix + component.Version + "-" + "configmap",
+		configSecretName(component, app),
+		prefix + component.Version + "-hpa",
+	}
+	for _, name := range names {
+		for _, msg := range validation.IsDNS1123Subdomain(name) {
+			errs = append(errs, field.Invalid(path.Child("version"), component.Version, "generated name "+name+": "+msg))
+		}
+	}
+	if component.WorkloadType == WorkloadTypeStatefulSet {
+		name := headlessServiceName(component, app)
+		for _, msg := range validation.IsDNS1035Label(name) {
+			errs = append(errs, field.Invalid(path.Child("version"), component.Version, "generated service name "+name+": "+msg))
+		}
+	}
+	return errs
+}
+
+// validateImagePullConfig every field is needed for the registry secret, the password is never part of an error
+func validateImagePullConfig(config *v3.ImagePullConfig, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if config == nil {
+		return errs
+	}
+	if config.Registry == "" {
+		errs = append(errs, field.Required(path.Child("registry"), ""))
+	}
+	if config.Username == "" {
+		errs = append(errs, field.Required(path.Child("username"), ""))
+	}
+	if config.Password == "" {
+		errs = append(errs, field.Required(path.Child("password"), ""))
+	}
+	return errs
+}
+
+func validateComponent(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	// both are part of the generated object names
+	if component.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	} else {
+		for _, msg := range validation.IsDNS1123Label(component.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), component.Name, msg))
+		}
+	}
+	if component.Version == "" {
+		errs = append(errs, field.Required(path.Child("version"), ""))
+	} else {
+		// also the version label of the pods, dotted versions like v1.0.12 are fine
+		for _, msg := range validation.IsValidLabelValue(component.Version) {
+			errs = append(errs, field.Invalid(path.Child("version"), component.Version, msg))
+		}
+	}
+	for i := range component.Containers {
+		errs = append(errs, validateContainer(&component.Containers[i], path.Child("containers").Index(i))...)
+	}
+	errs = append(errs, validateWorkload(component, path)...)
+	errs = append(errs, validateInitContainers(component, path)...)
+	errs = append(errs, validateSidecars(component, path)...)
+	for i, setting := range component.WorkloadSettings {
+		if setting.Name != SettingStartupSeconds {
+			continue
+		}
+		if _, err := startupSeconds(component); err != nil {
+			errs = append(errs, field.Invalid(path.Child("workloadSetings").Index(i).Child("value"), workloadSetting(component, SettingStartupSeconds), "must be a non-negative number of seconds"))
+		}
+	}
+
+	traitsPath := path.Child("componentTraits")
+	if autoscaling := component.ComponentTraits.Autoscaling; autoscaling != nil {
+		if autoscaling.MinReplicas < 1 {
+			errs = append(errs, field.Invalid(traitsPath.Child("autoscaling", "minreplicas"), autoscaling.MinReplicas, "must be greater than or equal to 1"))
+		}
+		if autoscaling.MaxReplicas < autoscaling.MinReplicas {
+			errs = append(errs, field.Invalid(traitsPath.Child("autoscaling", "maxreplicas"), autoscaling.MaxReplicas, "must be greater than or equal to minreplicas"))
+		}
+	}
+	if policy := component.ComponentTraits.SchedulePolicy; policy != nil {
+		policyPath := traitsPath.Child("schedulePolicy")
+		if policy.NodeAffinity != nil && policy.NodeAffinity.CLabelSelectorRequirement != nil {
+			errs = append(errs, validateRequirement(policy.NodeAffinity.CLabelSelectorRequirement, true, policyPath.Child("nodeAffinity", "labelSelectorRequirement"))...)
+		}
+		if policy.PodAffinity != nil && policy.PodAffinity.CLabelSelectorRequirement != nil {
+			errs = append(errs, validateRequirement(policy.PodAffinity.CLabelSelectorRequirement, false, policyPath.Child("podAffinity", "labelSelectorRequirement"))...)
+		}
+		if policy.PodAntiAffinity != nil && policy.PodAntiAffinity.CLabelSelectorRequirement != nil {
+			errs = append(errs, validateRequirement(policy.PodAntiAffinity.CLabelSelectorRequirement, false, policyPath.Child("podAntiAffinity", "labelSelectorRequirement"))...)
+		}
+	}
+	return errs
+}
+
+// WorkloadTypes the accepted component workloadType values
+var WorkloadTypes = []string{"", string(v3.Server), string(v3.SingletonServer), string(v3.Worker), string(v3.SingletonWorker),
+	string(v3.Task), string(v3.SingletonTask), string(WorkloadTypeStatefulSet), string(WorkloadTypeDaemonSet),
+	string(WorkloadTypeJob), string(WorkloadTypeCronJob)}
+
+// validateWorkload check workloadType and the workload settings it reads
+func validateWorkload(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if !contains(WorkloadTypes, string(component.WorkloadType)) {
+		return append(errs, field.NotSupported(path.Child("workloadType"), component.WorkloadType, WorkloadTypes[1:]))
+	}
+	switch component.WorkloadType {
+	case WorkloadTypeDaemonSet:
+		return append(errs, validateDaemonSet(component, path)...)
+	case WorkloadTypeJob, WorkloadTypeCronJob:
+		return append(errs, validateBatch(component, path)...)
+	}
+	if component.WorkloadType != WorkloadTypeStatefulSet {
+		return errs
+	}
+	for i, setting := range component.WorkloadSettings {
+		settingPath := path.Child("workloadSetings").Index(i).Child("value")
+		value := workloadSetting(component, setting.Name)
+		switch setting.Name {
+		case SettingPodManagementPolicy:
+			if value != "" && value != "OrderedReady" && value != "Parallel" {
+				errs = append(errs, field.NotSupported(settingPath, value, []string{"OrderedReady", "Parallel"}))
+			}
+		case SettingPartition:
+			if partition, err := strconv.ParseInt(value, 10, 32); value != "" && (err != nil || partition < 0) {
+				errs = append(errs, field.Invalid(settingPath, value, "must be a non-negative integer"))
+			}
+		}
+	}
+	return errs
+}
+
+// validateVolume check the claim settings of a volume which is not ephemeral
+func validateVolume(volume *v3.CVolume, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if !persistent(*volume) {
+		return errs
+	}
+	// the size of the volume claim
+	if volume.Disk.Required == "" {
+		errs = append(errs, field.Required(path.Child("disk", "required"), "size of the volume claim"))
+	} else {
+		errs = append(errs, validateQuantity(volume.Disk.Required, path.Child("disk", "required"))...)
+	}
+	if !contains(AccessModes, volume.AccessMode) {
+		errs = append(errs, field.NotSupported(path.Child("accessMode"), volume.AccessMode, AccessModes[1:]))
+	}
+	if !contains(SharingPolicies, volume.SharingPolicy) {
+		errs = append(errs, field.NotSupported(path.Child("sharingPolicy"), volume.SharingPolicy, SharingPolicies[1:]))
+	}
+	return errs
+}
+
+// validateSharedVolumes check the declarations of a shared volume agree on size and access mode,
+// they are mounted from one claim
+func validateSharedVolumes(components []v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	declared := make(map[string]v3.CVolume)
+	for i, component := range components {
+		for j, container := range component.Containers {
+			for k, volume := range container.Resources.Volumes {
+				if !persistent(volume) || volume.SharingPolicy != SharingPolicyShared {
+					continue
+				}
+				first, ok := declared[volume.Name]
+				if !ok {
+					declared[volume.Name] = volume
+					continue
+				}
+				volumePath := path.Index(i).Child("containers").Index(j).Child("resources", "volumes").Index(k)
+				if volume.Disk.Required != first.Disk.Required {
+					errs = append(errs, field.Invalid(volumePath.Child("disk", "required"), volume.Disk.Required, "shared volume "+volume.Name+" is declared with size "+first.Disk.Required+" elsewhere"))
+				}
+				if getAccessMode(volume.AccessMode) != getAccessMode(first.AccessMode) {
+					errs = append(errs, field.Invalid(volumePath.Child("accessMode"), volume.AccessMode, "shared volume "+volume.Name+" is declared with access mode "+first.AccessMode+" elsewhere"))
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// validateInitContainers check the initContainers setting names containers of the component,
+// which can not have probes or lifecycle handlers, and leaves at least one regular container
+func validateInitContainers(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	names := initContainerNames(component)
+	if len(names) == 0 {
+		return errs
+	}
+	settingPath := path.Child("workloadSetings")
+	for i, setting := range component.WorkloadSettings {
+		if setting.Name == SettingInitContainers {
+			settingPath = settingPath.Index(i).Child("value")
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			errs = append(errs, field.Duplicate(settingPath, name))
+		}
+		seen[name] = true
+	}
+	found := 0
+	for i, container := range component.Containers {
+		if !seen[container.Name] {
+			continue
+		}
+		found++
+		containerPath := path.Child("containers").Index(i)
+		if container.LivenessProbe != nil {
+			errs = append(errs, field.Forbidden(containerPath.Child("livenessProbe"), "init containers run to completion and can not have probes"))
+		}
+		if container.ReadinessProbe != nil {
+			errs = append(errs, field.Forbidden(containerPath.Child("readinessProbe"), "init containers run to completion and can not have probes"))
+		}
+		if container.Lifecycle != nil {
+			errs = append(errs, field.Forbidden(containerPath.Child("lifecycle"), "init containers can not have lifecycle handlers"))
+		}
+	}
+	for _, name := range names {
+		if !contains(containerNames(component), name) {
+			errs = append(errs, field.NotFound(settingPath, name))
+		}
+	}
+	if found == len(component.Containers) {
+		errs = append(errs, field.Invalid(settingPath, workloadSetting(component, SettingInitContainers), "at least one container must not be an init container"))
+	}
+	return errs
+}
+
+// validateSidecars check the names of the sidecars setting. Whether the registry has them is only known
+// to the controller, the webhook does not read the registry ConfigMap.
+func validateSidecars(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, setting := range component.WorkloadSettings {
+		if setting.Name != SettingSidecars {
+			continue
+		}
+		settingPath := path.Child("workloadSetings").Index(i).Child("value")
+		if batchWorkload(component) {
+			errs = append(errs, field.Forbidden(settingPath, "jobs and cronjobs have no sidecars, their pods would never complete"))
+			continue
+		}
+		for _, name := range strings.Split(workloadSetting(component, SettingSidecars), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			for _, msg := range validation.IsDNS1123Label(name) {
+				errs = append(errs, field.Invalid(settingPath, name, msg))
+			}
+			if contains(containerNames(component), name) {
+				errs = append(errs, field.Duplicate(settingPath, name))
+			}
+		}
+	}
+	return errs
+}
+
+func containerNames(component *v3.Component) []string {
+	var names []string
+	for _, container := range component.Containers {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+// validateDaemonSet check the update strategy settings, daemonsets can not be autoscaled
+func validateDaemonSet(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, setting := range component.WorkloadSettings {
+		settingPath := path.Child("workloadSetings").Index(i).Child("value")
+		value := workloadSetting(component, setting.Name)
+		switch setting.Name {
+		case SettingUpdateStrategy:
+			if value != "" && value != "RollingUpdate" && value != "OnDelete" {
+				errs = append(errs, field.NotSupported(settingPath, value, []string{"RollingUpdate", "OnDelete"}))
+			}
+		case SettingMaxUnavailable:
+			if _, err := parseMaxUnavailable(value); value != "" && err != nil {
+				errs = append(errs, field.Invalid(settingPath, value, err.Error()))
+			}
+		}
+	}
+	if component.ComponentTraits.Autoscaling != nil {
+		errs = append(errs, field.Forbidden(path.Child("componentTraits", "autoscaling"), "a DaemonSet runs one pod on every selected node and can not be autoscaled"))
+	}
+	return errs
+}
+
+// validateBatch check the job and cronjob settings, jobs can not be autoscaled
+func validateBatch(component *v3.Component, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	scheduled := false
+	for i, setting := range component.WorkloadSettings {
+		settingPath := path.Child("workloadSetings").Index(i).Child("value")
+		value := workloadSetting(component, setting.Name)
+		switch setting.Name {
+		case SettingSchedule:
+			scheduled = value != ""
+			if value != "" && !validSchedule(value) {
+				errs = append(errs, field.Invalid(settingPath, value, "must be a cron schedule of five fields or a predefined one like @daily"))
+			}
+		case SettingConcurrencyPolicy:
+			if value != "" && value != "Allow" && value != "Forbid" && value != "Replace" {
+				errs = append(errs, field.NotSupported(settingPath, value, []string{"Allow", "Forbid", "Replace"}))
+			}
+		case SettingRestartPolicy:
+			if value != "" && value != "OnFailure" && value != "Never" {
+				errs = append(errs, field.NotSupported(settingPath, value, []string{"OnFailure", "Never"}))
+			}
+		case SettingBackoffLimit, SettingTTLSecondsAfterFinished, SettingSuccessfulJobsHistoryLimit, SettingFailedJobsHistoryLimit:
+			if i, err := strconv.ParseInt(value, 10, 32); value != "" && (err != nil || i < 0) {
+				errs = append(errs, field.Invalid(settingPath, value, "must be a non-negative integer"))
+			}
+		case SettingActiveDeadlineSeconds:
+			if i, err := strconv.ParseInt(value, 10, 32); value != "" && (err != nil || i < 1) {
+				errs = append(errs, field.Invalid(settingPath, value, "must be a positive integer"))
+			}
+		}
+	}
+	if component.WorkloadType == WorkloadTypeCronJob && !scheduled {
+		errs = append(errs, field.Required(path.Child("workloadSetings"), "a CronJob needs the "+SettingSchedule+" setting"))
+	}
+	if component.ComponentTraits.Autoscaling != nil {
+		errs = append(errs, field.Forbidden(path.Child("componentTraits", "autoscaling"), "a "+string(component.WorkloadType)+" can not be autoscaled"))
+	}
+	return errs
+}
+
+func validateContainer(container *v3.ComponentContainer, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if container.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	if container.Image == "" {
+		errs = append(errs, field.Required(path.Child("image"), ""))
+	}
+	if !contains(PullPolicies, string(container.ImagePullPolicy)) {
+		errs = append(errs, field.NotSupported(path.Child("imagePullPolicy"), container.ImagePullPolicy, PullPolicies[1:]))
+	}
+
+	resourcesPath := path.Child("resources")
+	errs = append(errs, validateQuantity(container.Resources.Cpu, resourcesPath.Child("cpu"))...)
+	errs = append(errs, validateQuantity(container.Resources.Memory, resourcesPath.Child("memory"))...)
+	if container.Resources.Gpu < 0 {
+		errs = append(errs, field.Invalid(resourcesPath.Child("gpu"), container.Resources.Gpu, "must be greater than or equal to 0"))
+	}
+	for i := range container.Resources.Volumes {
+		errs = append(errs, validateVolume(&container.Resources.Volumes[i], resourcesPath.Child("volumes").Index(i))...)
+	}
+
+	errs = append(errs, validateCommand(container.Command, path.Child("command"))...)
+	errs = append(errs, validateCommand(container.Args, path.Child("args"))...)
+
+	for i, port := range container.Ports {
+		if port.ContainerPort < 1 || port.ContainerPort > 65535 {
+			errs = append(errs, field.Invalid(path.Child("ports").Index(i).Child("containerPort"), port.ContainerPort, "must be between 1 and 65535, inclusive"))
+		}
+	}
+
+	for i, file := range container.Config {
+		errs = append(errs, validateConfig(file, path.Child("config").Index(i))...)
+	}
+
+	for i, env := range container.Env {
+		errs = append(errs, validateEnv(env, path.Child("env").Index(i))...)
+	}
+
+	if container.LivenessProbe != nil {
+		errs = append(errs, validateProbe(container.LivenessProbe, true, path.Child("livenessProbe"))...)
+	}
+	if container.ReadinessProbe != nil {
+		errs = append(errs, validateProbe(container.ReadinessProbe, false, path.Child("readinessProbe"))...)
+	}
+	if container.Lifecycle != nil {
+		if container.Lifecycle.PostStart != nil {
+			errs = append(errs, validateHandler(container.Lifecycle.PostStart, path.Child("lifecycle", "postStart"))...)
+		}
+		if container.Lifecycle.PreStop != nil {
+			errs = append(errs, validateHandler(container.Lifecycle.PreStop, path.Child("lifecycle", "preStop"))...)
+		}
+	}
+	return errs
+}
+
+// validateQuantity empty value means the default
+func validateQuantity(value string, path *field.Path) field.ErrorList {
+	if value == "" {
+		return nil
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return field.ErrorList{field.Invalid(path, value, err.Error())}
+	}
+	if quantity.Sign() <= 0 {
+		return field.ErrorList{field.Invalid(path, value, "must be greater than 0")}
+	}
+	return nil
+}
+
+// validateEnv fromParam must name a supported source, value is then ignored. The name is optional only as prefix of configMapRef and secretRef.
+// Whether the referenced configmaps and secrets exist is reported in the component status, they may be created later.
+func validateEnv(env v3.CEnvVar, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if env.Name != "" {
+		for _, msg := range validation.IsEnvVarName(env.Name) {
+			errs = append(errs, field.Invalid(path.Child("name"), env.Name, msg))
+		}
+	}
+	if env.FromParam == "" {
+		if env.Name == "" {
+			errs = append(errs, field.Required(path.Child("name"), ""))
+		}
+		return errs
+	}
+	s, err := parseEnvSource(env.FromParam)
+	if err == errUnknownEnvSource {
+		// older applications name a component parameter here, the value is used and the component status tells
+		return errs
+	}
+	if err != nil {
+		return append(errs, field.Invalid(path.Child("fromParam"), env.FromParam, err.Error()))
+	}
+	if env.Name == "" && s.source != EnvSourceConfigMap && s.source != EnvSourceSecret {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	switch s.source {
+	case EnvSourceConfigMapKey, EnvSourceConfigMap, EnvSourceSecretKey, EnvSourceSecret:
+		for _, msg := range validation.IsDNS1123Subdomain(s.name) {
+			errs = append(errs, field.Invalid(path.Child("fromParam"), env.FromParam, msg))
+		}
+	}
+	if s.key != "" && (s.source == EnvSourceConfigMapKey || s.source == EnvSourceSecretKey) {
+		for _, msg := range validation.IsConfigMapKey(s.key) {
+			errs = append(errs, field.Invalid(path.Child("fromParam"), env.FromParam, msg))
+		}
+	}
+	return errs
+}
+
+// validateConfig fileName is a configmap or secret key, fromParam must name a supported source.
+// The value is never part of an error, it may be sensitive.
+func validateConfig(file v3.ConfigFile, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if file.FileName != "" {
+		for _, msg := range validation.IsConfigMapKey(file.FileName) {
+			errs = append(errs, field.Invalid(path.Child("fileName"), file.FileName, msg))
+		}
+	}
+	s, err := parseConfigSource(file)
+	if err != nil {
+		return append(errs, field.Invalid(path.Child("fromParam"), file.FromParam, err.Error()))
+	}
+	if s.source == ConfigSecretKey {
+		for _, msg := range validation.IsDNS1123Subdomain(s.name) {
+			errs = append(errs, field.Invalid(path.Child("fromParam"), file.FromParam, msg))
+		}
+		for _, msg := range validation.IsConfigMapKey(s.key) {
+			errs = append(errs, field.Invalid(path.Child("fromParam"), file.FromParam, msg))
+		}
+	}
+	return errs
+}
+
+// validateCommand every entry must parse in its mode, shell: entries need balanced quotes
+func validateCommand(entries []string, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, entry := range entries {
+		if _, err := splitCommand(entry); err != nil {
+			errs = append(errs, field.Invalid(path.Index(i), entry, err.Error()))
+		}
+	}
+	return errs
+}
+
+// validateHandler exactly one of exec, httpGet and tcpSocket must be set
+func validateHandler(handler *v3.Handler, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	var set []string
+	if handler.Exec != nil {
+		set = append(set, "exec")
+		if len(handler.Exec.Command) == 0 {
+			errs = append(errs, field.Required(path.Child("exec", "command"), ""))
+		}
+		errs = append(errs, validateCommand(handler.Exec.Command, path.Child("exec", "command"))...)
+	}
+	if handler.HTTPGet != nil {
+		set = append(set, "httpGet")
+		errs = append(errs, validateHTTPGet(handler.HTTPGet, path.Child("httpGet"))...)
+	}
+	if handler.TCPSocket != nil {
+		set = append(set, "tcpSocket")
+		if handler.TCPSocket.Port < 1 || handler.TCPSocket.Port > 65535 {
+			errs = append(errs, field.Invalid(path.Child("tcpSocket", "port"), handler.TCPSocket.Port, "must be between 1 and 65535, inclusive"))
+		}
+	}
+	switch {
+	case len(set) == 0:
+		errs = append(errs, field.Required(path, "must specify one of exec, httpGet and tcpSocket"))
+	case len(set) > 1:
+		errs = append(errs, field.Forbidden(path.Child(set[1]), fmt.Sprintf("may not specify more than 1 handler type, %s is already set", set[0])))
+	}
+	return errs
+}
+
+// validateHTTPGet the port may be given by the url form of path, as number or container port name
+func validateHTTPGet(get *v3.HTTPGetAction, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if get.Path == "" {
+		errs = append(errs, field.Required(path.Child("path"), ""))
+	}
+	if strings.Contains(get.Path, "://") {
+		if _, _, ok := splitScheme(get.Path); !ok {
+			errs = append(errs, field.Invalid(path.Child("path"), get.Path, "url scheme must be http or https"))
+		}
+	}
+	action, err := getHTTPGetAction(get)
+	switch {
+	case err == errHTTPGetNoPort:
+		errs = append(errs, field.Required(path.Child("port"), "must be set when path has no port"))
+	case err != nil:
+		errs = append(errs, field.Invalid(path.Child("path"), get.Path, err.Error()))
+	case action != nil && action.Port.Type == intstr.String:
+		for _, msg := range validation.IsValidPortName(action.Port.StrVal) {
+			errs = append(errs, field.Invalid(path.Child("path"), get.Path, "port "+msg))
+		}
+	case action != nil && (action.Port.IntVal < 1 || action.Port.IntVal > 65535):
+		errs = append(errs, field.Invalid(path.Child("port"), action.Port.IntVal, "must be between 1 and 65535, inclusive"))
+	}
+	for i, header := range get.HTTPHeaders {
+		if header.Name == "" {
+			errs = append(errs, field.Required(path.Child("httpHeaders").Index(i).Child("name"), ""))
+		}
+	}
+	return errs
+}
+
+// validateProbe check the handler and timings, 0 means the kubernetes default. Liveness probes
+// must succeed once, more successes are only meaningful for readiness.
+func validateProbe(probe *v3.HealthProbe, liveness bool, path *field.Path) field.ErrorList {
+	errs := validateHandler(&probe.Handler, path)
+	for name, value := range map[string]int32{
+		"initialDelaySeconds": probe.InitialDelaySeconds,
+		"timeoutSeconds":      probe.TimeoutSeconds,
+		"periodSeconds":       probe.PeriodSeconds,
+		"successThreshold":    probe.SuccessThreshold,
+		"failureThreshold":    probe.FailureThreshold,
+	} {
+		if value < 0 {
+			errs = append(errs, field.Invalid(path.Child(name), value, "must be greater than or equal to 0"))
+		}
+	}
+	if liveness && probe.SuccessThreshold > 1 {
+		errs = append(errs, field.Invalid(path.Child("successThreshold"), probe.SuccessThreshold, "must be 1 for liveness probes"))
+	}
+	return errs
+}
+
+// validateGrayRelease weights route traffic between the component versions, they must sum to 100
+func validateGrayRelease(grayRelease map[string]int, versions map[string]bool, components int, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if len(grayRelease) == 0 {
+		if len(versions) > 1 {
+			errs = append(errs, field.Required(path, "must assign weights to the component versions when there are more than one"))
+		}
+		return errs
+	}
+	if components < 2 {
+		// ignored by the controller, the only version gets all traffic
+		return errs
+	}
+
+	var keys []string
+	for version := range grayRelease {
+		keys = append(keys, version)
+	}
+	sort.Strings(keys)
+	sum := 0
+	for _, version := range keys {
+		weight := grayRelease[version]
+		if !versions[version] {
+			errs = append(errs, field.NotFound(path.Key(version), version))
+		}
+		if weight < 0 || weight > 100 {
+			errs = append(errs, field.Invalid(path.Key(version), weight, "must be between 0 and 100, inclusive"))
+		}
+		sum += weight
+	}
+	if len(grayRelease) < 2 {
+		errs = append(errs, field.Invalid(path, keys, "must assign weights to at least 2 component versions"))
+	}
+	if sum != 100 {
+		errs = append(errs, field.Invalid(path, sum, "weights must sum to 100"))
+	}
+	return errs
+}
+
+func validateIngress(ingress *v3.AppIngress, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if ingress.Host == "" {
+		errs = append(errs, field.Required(path.Child("host"), ""))
+	}
+	if ingress.ServerPort == 0 {
+		errs = append(errs, field.Required(path.Child("serverPort"), ""))
+	} else if ingress.ServerPort < 1 || ingress.ServerPort > 65535 {
+		errs = append(errs, field.Invalid(path.Child("serverPort"), ingress.ServerPort, "must be between 1 and 65535, inclusive"))
+	}
+	return errs
+}
+
+// validateRequirement In and NotIn need values, Exists and DoesNotExist forbid them.
+// Node affinity additionally accepts Gt and Lt with a single integer value.
+func validateRequirement(requirement *v3.CLabelSelectorRequirement, node bool, path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if requirement.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), ""))
+	}
+	operators := []string{string(v3.LabelSelectorOpIn), string(v3.LabelSelectorOpNotIn), string(v3.LabelSelectorOpExists), string(v3.LabelSelectorOpDoesNotExist)}
+	if node {
+		operators = append(operators, "Gt", "Lt")
+	}
+	valuesPath := path.Child("values")
+	switch requirement.Operator {
+	case v3.LabelSelectorOpIn, v3.LabelSelectorOpNotIn:
+		if len(requirement.Values) == 0 {
+			errs = append(errs, field.Required(valuesPath, "must be specified when operator is In or NotIn"))
+		}
+	case v3.LabelSelectorOpExists, v3.LabelSelectorOpDoesNotExist:
+		if len(requirement.Values) != 0 {
+			errs = append(errs, field.Forbidden(valuesPath, "may not be specified when operator is Exists or DoesNotExist"))
+		}
+	case "Gt", "Lt":
+		if !node {
+			errs = append(errs, field.NotSupported(path.Child("operator"), requirement.Operator, operators))
+			break
+		}
+		if len(requirement.Values) != 1 {
+			errs = append(errs, field.Invalid(valuesPath, requirement.Values, "must have a single element when operator is Gt or Lt"))
+		} else if _, err := strconv.ParseInt(requirement.Values[0], 10, 64); err != nil {
+			errs = append(errs, field.Invalid(valuesPath.Index(0), requirement.Values[0], "must be an integer when operator is Gt or Lt"))
+		}
+	default:
+		errs = append(errs, field.NotSupported(path.Child("operator"), requirement.Operator, operators))
+	}
+	return errs
+}
+
+func contains(list []string, value string) bool {
+	for _, i := range list {
+		if i == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// newValidApplication an application with one Server component which passes ValidateApplication, changed by mutate
+func newValidApplication(mutate func(app *v3.Application, component *v3.Component)) *v3.Application {
+	app := &v3.Application{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "demo"},
+		Spec: v3.ApplicationSpec{
+			Components: []v3.Component{{
+				Name:         "web",
+				Version:      "v1",
+				WorkloadType: v3.Server,
+				Containers:   []v3.ComponentContainer{{Name: "web", Image: "nginx"}},
+			}},
+		},
+	}
+	app.Spec.OptTraits.Ingress = v3.AppIngress{Host: "demo.example.com", ServerPort: 80}
+	if mutate != nil {
+		mutate(app, &app.Spec.Components[0])
+	}
+	return app
+}
+
+func settings(pairs ...string) []v3.WorkloadSetting {
+	var result []v3.WorkloadSetting
+	for i := 0; i+1 < len(pairs); i += 2 {
+		result = append(result, v3.WorkloadSetting{Name: pairs[i], Type: "string", Value: pairs[i+1]})
+	}
+	return result
+}
+
+// errorFields the type and path of the errors, e.g. "FieldValueRequired spec.components[0].version",
+// repeated ones like an invalid version in every generated name once
+func errorFields(errs field.ErrorList) []string {
+	var fields []string
+	for _, err := range errs {
+		if f := string(err.Type) + " " + err.Field; !contains(fields, f) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func TestValidateApplication(t *testing.T) {
+	const component = "spec.components[0]"
+	tests := []struct {
+		name   string
+		mutate func(app *v3.Application, component *v3.Component)
+		// want the errors as errorFields gives them, none when empty
+		want []string
+	}{
+		{name: "valid"},
+		{name: "dotted version", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Version = "v1.0.12"
+		}},
+		{name: "version not a label value", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Version = "v1/2"
+		}, want: []string{"FieldValueInvalid " + component + ".version"}},
+		{name: "generated names too long", mutate: func(app *v3.Application, c *v3.Component) {
+			app.Name = strings.Repeat("a", 250)
+		}, want: []string{"FieldValueInvalid " + component + ".version"}},
+		{name: "statefulset dotted version", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeStatefulSet
+			c.Version = "v1.0.12"
+		}, want: []string{"FieldValueInvalid " + component + ".version"}},
+		{name: "missing name and image", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Name = ""
+			c.Containers[0].Image = ""
+		}, want: []string{"FieldValueRequired " + component + ".name", "FieldValueRequired " + component + ".containers[0].image"}},
+		{name: "invalid quantities", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Resources.Cpu = "-100m"
+			c.Containers[0].Resources.Memory = "1 Gi"
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].resources.cpu", "FieldValueInvalid " + component + ".containers[0].resources.memory"}},
+		{name: "container port out of range", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Ports = []v3.AppPort{{ContainerPort: 70000}}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].ports[0].containerPort"}},
+		{name: "env sources", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Env = []v3.CEnvVar{{Name: "HOST_IP", FromParam: "status.hostIP"}, {FromParam: "configMapRef:settings"}}
+		}},
+		{name: "component parameter env source", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Env = []v3.CEnvVar{{Name: "DB_HOST", FromParam: "dbHost", Value: "mysql"}}
+		}},
+		{name: "malformed env source", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Env = []v3.CEnvVar{{Name: "DB_HOST", FromParam: "configMapKeyRef:db"}}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].env[0].fromParam"}},
+		{name: "config sources", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Config = []v3.ConfigFile{
+				{FileName: "app.conf", Path: "/etc/app", Value: "a=1"},
+				{FileName: "db.conf", Path: "/etc/app", Value: "password=1", FromParam: ConfigSensitive},
+				{FileName: "tls.crt", Path: "/etc/tls", FromParam: "secretKeyRef:tls/tls.crt"},
+			}
+		}},
+		{name: "unknown config source", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Config = []v3.ConfigFile{{FileName: "app.conf", Path: "/etc/app", FromParam: "configMapKeyRef:cm/key"}}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].config[0].fromParam"}},
+		{name: "config file name not a key", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Config = []v3.ConfigFile{{FileName: "etc/app.conf", Path: "/etc/app", Value: "a=1"}}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].config[0].fileName"}},
+		{name: "command with a quote", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Command = []string{"echo it's", "verbatim:it's"}
+		}},
+		{name: "unbalanced shell command", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Command = []string{"shell:echo it's"}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].command[0]"}},
+		{name: "httpGet without port", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].LivenessProbe = &v3.HealthProbe{Handler: v3.Handler{HTTPGet: &v3.HTTPGetAction{Path: "/healthz"}}}
+		}, want: []string{"FieldValueRequired " + component + ".containers[0].livenessProbe.httpGet.port"}},
+		{name: "httpGet named port", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].ReadinessProbe = &v3.HealthProbe{Handler: v3.Handler{HTTPGet: &v3.HTTPGetAction{Path: "http://:metrics/ready"}}}
+		}},
+		{name: "liveness success threshold", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].LivenessProbe = &v3.HealthProbe{SuccessThreshold: 2, Handler: v3.Handler{TCPSocket: &v3.TCPSocketAction{Port: 80}}}
+		}, want: []string{"FieldValueInvalid " + component + ".containers[0].livenessProbe.successThreshold"}},
+		{name: "startup seconds", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadSettings = settings(SettingStartupSeconds, "-5")
+		}, want: []string{"FieldValueInvalid " + component + ".workloadSetings[0].value"}},
+		{name: "probe with two handlers", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].LivenessProbe = &v3.HealthProbe{Handler: v3.Handler{
+				Exec:      &v3.ExecAction{Command: []string{"true"}},
+				TCPSocket: &v3.TCPSocketAction{Port: 80},
+			}}
+		}, want: []string{"FieldValueForbidden " + component + ".containers[0].livenessProbe.tcpSocket"}},
+		{name: "two versions without gray release", mutate: func(app *v3.Application, c *v3.Component) {
+			next := *c.DeepCopy()
+			next.Version = "v2"
+			app.Spec.Components = append(app.Spec.Components, next)
+		}, want: []string{"FieldValueRequired spec.optTraits.grayRelease"}},
+		{name: "gray release weights", mutate: func(app *v3.Application, c *v3.Component) {
+			next := *c.DeepCopy()
+			next.Version = "v2"
+			app.Spec.Components = append(app.Spec.Components, next)
+			app.Spec.OptTraits.GrayRelease = map[string]int{"v1": 90, "v3": 20}
+		}, want: []string{"FieldValueNotFound spec.optTraits.grayRelease[v3]", "FieldValueInvalid spec.optTraits.grayRelease"}},
+		{name: "unknown workload type", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = "Deployment"
+		}, want: []string{"FieldValueNotSupported " + component + ".workloadType"}},
+		{name: "statefulset settings", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeStatefulSet
+			c.WorkloadSettings = settings(SettingPodManagementPolicy, "Parallel", SettingPartition, "1")
+		}},
+		{name: "statefulset invalid settings", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeStatefulSet
+			c.WorkloadSettings = settings(SettingPodManagementPolicy, "Random", SettingPartition, "-1")
+		}, want: []string{"FieldValueNotSupported " + component + ".workloadSetings[0].value", "FieldValueInvalid " + component + ".workloadSetings[1].value"}},
+		{name: "statefulset volume without size", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeStatefulSet
+			c.Containers[0].Resources.Volumes = []v3.CVolume{{Name: "data", MountPath: "/data"}}
+		}, want: []string{"FieldValueRequired " + component + ".containers[0].resources.volumes[0].disk.required"}},
+		{name: "volume on a node directory", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].Resources.Volumes = []v3.CVolume{{Name: "data", MountPath: "/data", Disk: v3.Disk{Required: "/var/lib/web"}}}
+		}},
+		{name: "daemonset", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+			c.WorkloadSettings = settings(SettingUpdateStrategy, "RollingUpdate", SettingMaxUnavailable, "10%")
+		}},
+		{name: "daemonset invalid settings", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+			c.WorkloadSettings = settings(SettingUpdateStrategy, "Recreate", SettingMaxUnavailable, "0")
+		}, want: []string{"FieldValueNotSupported " + component + ".workloadSetings[0].value", "FieldValueInvalid " + component + ".workloadSetings[1].value"}},
+		{name: "daemonset autoscaling", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+			c.ComponentTraits.Autoscaling = &v3.Autoscaling{MinReplicas: 1, MaxReplicas: 2}
+		}, want: []string{"FieldValueForbidden " + component + ".componentTraits.autoscaling"}},
+		{name: "job settings", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeJob
+			c.WorkloadSettings = settings(SettingRestartPolicy, "Never", SettingBackoffLimit, "3", SettingActiveDeadlineSeconds, "600")
+		}},
+		{name: "job invalid settings", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeJob
+			c.WorkloadSettings = settings(SettingRestartPolicy, "Always", SettingActiveDeadlineSeconds, "0")
+		}, want: []string{"FieldValueNotSupported " + component + ".workloadSetings[0].value", "FieldValueInvalid " + component + ".workloadSetings[1].value"}},
+		{name: "job sidecars", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeJob
+			c.WorkloadSettings = settings(SettingBackoffLimit, "1", SettingSidecars, "envoy")
+		}, want: []string{"FieldValueForbidden " + component + ".workloadSetings[1].value"}},
+		{name: "cronjob", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeCronJob
+			c.WorkloadSettings = settings(SettingSchedule, "*/5 * * * *", SettingConcurrencyPolicy, "Forbid")
+		}},
+		{name: "cronjob invalid schedule", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeCronJob
+			c.WorkloadSettings = settings(SettingSchedule, "*/5 * *")
+		}, want: []string{"FieldValueInvalid " + component + ".workloadSetings[0].value"}},
+		{name: "cronjob without schedule", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeCronJob
+		}, want: []string{"FieldValueRequired " + component + ".workloadSetings"}},
+		{name: "cronjob autoscaling", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeCronJob
+			c.WorkloadSettings = settings(SettingSchedule, "@daily")
+			c.ComponentTraits.Autoscaling = &v3.Autoscaling{MinReplicas: 1, MaxReplicas: 2}
+		}, want: []string{"FieldValueForbidden " + component + ".componentTraits.autoscaling"}},
+		{name: "ingress required", mutate: func(app *v3.Application, c *v3.Component) {
+			app.Spec.OptTraits.Ingress = v3.AppIngress{}
+		}, want: []string{"FieldValueRequired spec.optTraits.ingress.host", "FieldValueRequired spec.optTraits.ingress.serverPort"}},
+		{name: "daemonsets only need no ingress", mutate: func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeDaemonSet
+			app.Spec.OptTraits.Ingress = v3.AppIngress{}
+		}},
+		{name: "image pull policy", mutate: func(app *v3.Application, c *v3.Component) {
+			c.Containers[0].ImagePullPolicy = "Sometimes"
+		}, want: []string{"FieldValueNotSupported " + component + ".containers[0].imagePullPolicy"}},
+		{name: "image pull config without password", mutate: func(app *v3.Application, c *v3.Component) {
+			app.Spec.OptTraits.ImagePullConfig = &v3.ImagePullConfig{Registry: "registry.example.com", Username: "ci"}
+		}, want: []string{"FieldValueRequired spec.optTraits.imagePullConfig.password"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorFields(ValidateApplication(newValidApplication(tt.mutate)))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ValidateApplication errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestValidateApplicationUpdate(t *testing.T) {
+	statefulset := func(mutate func(c *v3.Component)) *v3.Application {
+		return newValidApplication(func(app *v3.Application, c *v3.Component) {
+			c.WorkloadType = WorkloadTypeStatefulSet
+			if mutate != nil {
+				mutate(c)
+			}
+		})
+	}
+	old := statefulset(nil)
+	tests := []struct {
+		name string
+		app  *v3.Application
+		want []string
+	}{
+		{name: "unchanged", app: statefulset(nil)},
+		{name: "image changed", app: statefulset(func(c *v3.Component) {
+			c.Containers[0].Image = "nginx:1.15"
+		})},
+		{name: "partition changed", app: statefulset(func(c *v3.Component) {
+			c.WorkloadSettings = settings(SettingPartition, "1")
+		})},
+		{name: "pod management policy changed", app: statefulset(func(c *v3.Component) {
+			c.WorkloadSettings = settings(SettingPodManagementPolicy, "Parallel")
+		}), want: []string{"FieldValueForbidden spec.components[0].workloadSetings"}},
+		{name: "new version", app: statefulset(func(c *v3.Component) {
+			c.Version = "v2"
+			c.WorkloadSettings = settings(SettingPodManagementPolicy, "Parallel")
+		})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := errorFields(ValidateApplicationUpdate(tt.app, old))
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("ValidateApplicationUpdate errors\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
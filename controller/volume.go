@@ -0,0 +1,131 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Volume sharingPolicy values
+const (
+	// SharingPolicyExclusive the claim belongs to one component, the default
+	SharingPolicyExclusive = "Exclusive"
+	// SharingPolicyShared one claim per volume name, mounted by every component declaring it
+	SharingPolicyShared = "Shared"
+)
+
+// persistent report whether volume is backed by a claim, ephemeral volumes are emptyDir
+func persistent(volume v3.CVolume) bool {
+	return volume.Name != "" && volume.MountPath != "" && !volume.Disk.Ephemeral && !hostPath(volume)
+}
+
+// hostPath report whether disk.required of volume is an absolute path instead of a size. The controller
+// mounted such volumes from that directory of the node before claims, they stay hostPath so the data
+// already on the node is not left behind.
+func hostPath(volume v3.CVolume) bool {
+	return !volume.Disk.Ephemeral && strings.HasPrefix(volume.Disk.Required, "/")
+}
+
+// claimName name of the claim volume of component is mounted from. Claims are not named after the
+// version, the data survives version changes. They are owned by the application and removed with it.
+func claimName(component *v3.Component, app *v3.Application, volume v3.CVolume) string {
+	if volume.SharingPolicy == SharingPolicyShared {
+		return app.Name + "-" + volume.Name
+	}
+	return app.Name + "-" + component.Name + "-" + volume.Name
+}
+
+// NewPersistentVolumeClaimObject Use for generate PersistentVolumeClaimObject, disk.required is the size,
+// the storage class is the one of the volumeMounter trait
+func NewPersistentVolumeClaimObject(app *v3.Application, name string, volume v3.CVolume) (corev1.PersistentVolumeClaim, error) {
+	spec, err := newClaimSpec(app, volume)
+	if err != nil {
+		return corev1.PersistentVolumeClaim{}, err
+	}
+	return corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(app, v3.SchemeGroupVersion.WithKind("Application"))},
+			Namespace:       app.Namespace,
+			Name:            name,
+		},
+		Spec: spec,
+	}, nil
+}
+
+func newClaimSpec(app *v3.Application, volume v3.CVolume) (corev1.PersistentVolumeClaimSpec, error) {
+	size, err := resource.ParseQuantity(volume.Disk.Required)
+	if err != nil {
+		return corev1.PersistentVolumeClaimSpec{}, fmt.Errorf("disk size %q of volume %s is invalid: %s", volume.Disk.Required, volume.Name, err.Error())
+	}
+	spec := corev1.PersistentVolumeClaimSpec{
+		AccessModes: []corev1.PersistentVolumeAccessMode{getAccessMode(volume.AccessMode)},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceStorage: size},
+		},
+	}
+	if app.Spec.OptTraits.VolumeMounter != nil && app.Spec.OptTraits.VolumeMounter.StorageClass != "" {
+		storageClass := app.Spec.OptTraits.VolumeMounter.StorageClass
+		spec.StorageClassName = &storageClass
+	}
+	return spec, nil
+}
+
+// getClaims the claims the pods of component mount. Exclusive volumes of a StatefulSet are
+// volumeClaimTemplates, one claim per pod, and not part of the result.
+func getClaims(component *v3.Component, app *v3.Application) ([]corev1.PersistentVolumeClaim, error) {
+	var claims []corev1.PersistentVolumeClaim
+	for _, container := range component.Containers {
+		for _, volume := range container.Resources.Volumes {
+			if !persistent(volume) {
+				continue
+			}
+			if component.WorkloadType == WorkloadTypeStatefulSet && volume.SharingPolicy != SharingPolicyShared {
+				continue
+			}
+			name := claimName(component, app, volume)
+			if claimed(claims, name) {
+				// mounted by several containers
+				continue
+			}
+			claim, err := NewPersistentVolumeClaimObject(app, name, volume)
+			if err != nil {
+				return nil, err
+			}
+			claims = append(claims, claim)
+		}
+	}
+	return claims, nil
+}
+
+// getAccessMode accept the kubernetes names and their short forms, ReadWriteOnce by default
+func getAccessMode(mode string) corev1.PersistentVolumeAccessMode {
+	switch mode {
+	case "RWX", string(corev1.ReadWriteMany):
+		return corev1.ReadWriteMany
+	case "ROX", string(corev1.ReadOnlyMany):
+		return corev1.ReadOnlyMany
+	}
+	return corev1.ReadWriteOnce
+}
+
+func claimed(claims []corev1.PersistentVolumeClaim, name string) bool {
+	for _, claim := range claims {
+		if claim.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasVolume(volumes []corev1.Volume, name string) bool {
+	for _, volume := range volumes {
+		if volume.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package controller
+
+import (
+	"testing"
+
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+)
+
+func TestHostPathVolume(t *testing.T) {
+	app := newValidApplication(func(app *v3.Application, c *v3.Component) {
+		c.Containers[0].Resources.Volumes = []v3.CVolume{
+			{Name: "legacy", MountPath: "/legacy", Disk: v3.Disk{Required: "/var/lib/web"}},
+			{Name: "data", MountPath: "/data", Disk: v3.Disk{Required: "1Gi"}},
+		}
+	})
+	component := &app.Spec.Components[0]
+
+	claims, err := getClaims(component, app)
+	if err != nil {
+		t.Fatalf("getClaims: %s", err.Error())
+	}
+	if len(claims) != 1 || claims[0].Name != claimName(component, app, component.Containers[0].Resources.Volumes[1]) {
+		t.Errorf("claims = %v, want only the claim of data", claims)
+	}
+
+	deploy, err := NewDeployObject(component, app)
+	if err != nil {
+		t.Fatalf("NewDeployObject: %s", err.Error())
+	}
+	for _, volume := range deploy.Spec.Template.Spec.Volumes {
+		switch volume.Name {
+		case component.Name + "-legacy":
+			if volume.HostPath == nil || volume.HostPath.Path != "/var/lib/web" {
+				t.Errorf("volume legacy = %+v, want hostPath /var/lib/web", volume.VolumeSource)
+			}
+		case component.Name + "-data":
+			if volume.PersistentVolumeClaim == nil {
+				t.Errorf("volume data = %+v, want the claim", volume.VolumeSource)
+			}
+		}
+	}
+}
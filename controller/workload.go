@@ -2,20 +2,18 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
 
-	"reflect"
-
 	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/kubernetes/pkg/credentialprovider"
 )
 
@@ -28,6 +26,10 @@ func NewConfigMapObject(component *v3.Component, app *v3.Application) corev1.Con
 				log.Errorf("%s-%s's configmap configuration's filename is nil,please check configration", component.Name, component.Version)
 				continue
 			}
+			if j.FromParam != "" {
+				// sensitive or from an existing secret
+				continue
+			}
 			stringmap[j.FileName] = j.Value
 		}
 	}
@@ -42,12 +44,38 @@ func NewConfigMapObject(component *v3.Component, app *v3.Application) corev1.Con
 	return configmap
 }
 
-// NewSecretObject Use for generate SecretObject
-func NewSecretObject(app *v3.Application) corev1.Secret {
-	dockercfgJSONContent, err := handleDockerCfgJSONContent(app.Spec.OptTraits.ImagePullConfig.Username, app.Spec.OptTraits.ImagePullConfig.Password, "", app.Spec.OptTraits.ImagePullConfig.Registry)
+// NewConfigSecretObject Use for generate the secret of the sensitive config files of component
+func NewConfigSecretObject(component *v3.Component, app *v3.Application) corev1.Secret {
+	datamap := make(map[string][]byte)
+	for _, i := range component.Containers {
+		for _, j := range i.Config {
+			if j.FileName == "" || j.FromParam != ConfigSensitive {
+				continue
+			}
+			datamap[j.FileName] = []byte(j.Value)
+		}
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(app, v3.SchemeGroupVersion.WithKind("Application"))},
+			Namespace:       app.Namespace,
+			Name:            configSecretName(component, app),
+		},
+		Data: datamap,
+		Type: corev1.SecretTypeOpaque,
+	}
+	return secret
+}
+
+// NewSecretObject Use for generate SecretObject, the dockerconfigjson of optTraits.imagePullConfig
+func NewSecretObject(app *v3.Application) (corev1.Secret, error) {
+	config := app.Spec.OptTraits.ImagePullConfig
+	if config == nil {
+		return corev1.Secret{}, nil
+	}
+	dockercfgJSONContent, err := handleDockerCfgJSONContent(config.Username, config.Password, "", config.Registry)
 	if err != nil {
-		log.Errorf("Create docker secret failed for %s", app.Namespace)
-		return corev1.Secret{}
+		return corev1.Secret{}, fmt.Errorf("create docker config of registry %s failed: %s", config.Registry, err.Error())
 	}
 	datamap := map[string][]byte{}
 	datamap[corev1.DockerConfigJsonKey] = dockercfgJSONContent
@@ -55,12 +83,17 @@ func NewSecretObject(app *v3.Application) corev1.Secret {
 		ObjectMeta: metav1.ObjectMeta{
 			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(app, v3.SchemeGroupVersion.WithKind("Application"))},
 			Namespace:       app.Namespace,
-			Name:            app.Name + "-" + "registry-secret",
+			Name:            imagePullSecretName(app),
 		},
 		Data: datamap,
 		Type: corev1.SecretTypeDockerConfigJson,
 	}
-	return secret
+	return secret, nil
+}
+
+// imagePullSecretName name of the registry secret of app, attached to every pod after the admin secret
+func imagePullSecretName(app *v3.Application) string {
+	return app.Name + "-" + "registry-secret"
 }
 
 // handleDockerCfgJSONContent serializes a ~/.docker/config.json file
@@ -78,8 +111,36 @@ func handleDockerCfgJSONContent(username, password, email, server string) ([]byt
 	return json.Marshal(dockerCfgJSON)
 }
 
+// getNodeSelector DefaultNodeSelector, which DaemonSets do not get, overridden by the nodeSelector of the schedulePolicy
+// where an empty value removes the key. type is GPU whenever a container requests gpu.
+// SetDefaults writes the same into the schedulePolicy, the defaults are applied here again for undefaulted applications.
+func getNodeSelector(component *v3.Component) map[string]string {
+	selector := make(map[string]string)
+	if component.WorkloadType != WorkloadTypeDaemonSet {
+		for k, v := range DefaultNodeSelector {
+			selector[k] = v
+		}
+	}
+	if policy := component.ComponentTraits.SchedulePolicy; policy != nil {
+		for k, v := range policy.NodeSelector {
+			if v == "" {
+				delete(selector, k)
+				continue
+			}
+			selector[k] = v
+		}
+	}
+	for _, i := range component.Containers {
+		if i.Resources.Gpu > 0 {
+			selector["type"] = "GPU"
+			break
+		}
+	}
+	return selector
+}
+
 // NewDeployObject Use for generate DeployObject
-func NewDeployObject(component *v3.Component, app *v3.Application) appsv1beta2.Deployment {
+func NewDeployObject(component *v3.Component, app *v3.Application) (appsv1beta2.Deployment, error) {
 	//ownerRef := GetOwnerRef(app)
 	var volumes []corev1.Volume //zk
 	for _, i := range component.Containers {
@@ -87,66 +148,71 @@ func NewDeployObject(component *v3.Component, app *v3.Application) appsv1beta2.D
 			if j.Name == "" || j.MountPath == "" {
 				continue
 			}
+			if hasVolume(volumes, component.Name+"-"+j.Name) {
+				// mounted by several containers
+				continue
+			}
 			if j.Disk.Ephemeral {
 				volumes = append(volumes, corev1.Volume{Name: component.Name + "-" + j.Name,
 					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
 				})
-			} else {
+			} else if hostPath(j) {
 				var pathtype corev1.HostPathType = corev1.HostPathDirectoryOrCreate
 				volumes = append(volumes, corev1.Volume{Name: component.Name + "-" + j.Name,
 					VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: j.Disk.Required,
 						Type: &pathtype},
 					}})
+			} else {
+				volumes = append(volumes, corev1.Volume{Name: component.Name + "-" + j.Name,
+					VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+						ClaimName: claimName(component, app, j)},
+					}})
 			}
 		}
-		for _, k := range i.Config {
-			if k.FileName == "" || k.Path == "" || k.Value == "" {
-				continue
-			}
-			volumes = append(volumes, corev1.Volume{Name: component.Name + "-" + component.Version + "-" + strings.Replace(strings.Replace(k.FileName, ".", "-", -1), "_", "-", -1),
-				VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: app.Name + "-" + component.Name + "-" + component.Version + "-" + "configmap"},
-					Items: []corev1.KeyToPath{
-						{
-							Key:  k.FileName,
-							Path: "path/to/" + k.FileName,
-						}},
-				}}})
+	}
+	annotations := make(map[string]string)
+	if checksum := configChecksum(component, app); checksum != "" {
+		annotations[ConfigChecksumAnnotation] = checksum
+	}
+	configVolumes, err := getConfigVolumes(component, app)
+	if err != nil {
+		return appsv1beta2.Deployment{}, err
+	}
+	volumes = append(volumes, configVolumes...)
+	containers, err := getContainers(component)
+	if err != nil {
+		return appsv1beta2.Deployment{}, err
+	}
+	sidecarContainers, sidecarVolumes, err := getSidecars(component, app)
+	if err != nil {
+		return appsv1beta2.Deployment{}, err
+	}
+	containers = append(containers, sidecarContainers...)
+	for _, volume := range sidecarVolumes {
+		if !hasVolume(volumes, volume.Name) {
+			volumes = append(volumes, volume)
 		}
 	}
-	if component.ComponentTraits.Logcollect && os.Getenv("LOGCOLLECT_CONFIGMAP_NAME") != "" {
-		volumes = append(volumes, corev1.Volume{
-			Name:         component.Name + "-" + "logdir",
-			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
-		})
-		volumes = append(volumes, corev1.Volume{
-			Name: component.Name + "-" + "log" + "-" + "configmap",
-			VolumeSource: corev1.VolumeSource{
-				ConfigMap: &corev1.ConfigMapVolumeSource{
-					LocalObjectReference: corev1.LocalObjectReference{
-						Name: os.Getenv("LOGCOLLECT_CONFIGMAP_NAME"),
-					},
-				},
-			},
-		})
+	initContainers, err := getInitContainers(component)
+	if err != nil {
+		return appsv1beta2.Deployment{}, err
 	}
-	containers, _ := getContainers(component)
 	var imagepullsecret []corev1.LocalObjectReference
-	/*if app.Status.ComponentResource[(app.Name+"_"+component.Name+"_"+component.Version)].ImagePullSecret != "" {
-		imagepullsecret = append(imagepullsecret, corev1.LocalObjectReference{Name: app.Status.ComponentResource[(app.Name + "_" + component.Name + "_" + component.Version)].ImagePullSecret})
-	}*/
 	ztsecret := os.Getenv("ADMIN_IMAGEPULL_SECRET_NAME")
 	if ztsecret != "" {
 		imagepullsecret = append(imagepullsecret, corev1.LocalObjectReference{Name: ztsecret})
 	}
+	if app.Spec.OptTraits.ImagePullConfig != nil {
+		imagepullsecret = append(imagepullsecret, corev1.LocalObjectReference{Name: imagePullSecretName(app)})
+	}
 	deploy := appsv1beta2.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(app, v3.SchemeGroupVersion.WithKind("Application"))},
 			Namespace:       app.Namespace,
 			Name:            app.Name + "-" + component.Name + "-" + "workload" + "-" + component.Version,
-			Labels:          app.Labels,
-			Annotations:     app.Annotations,
+			// copies, the annotations of the application are not carried over
+			Labels:      copyStringMap(app.Labels),
+			Annotations: map[string]string{},
 		},
 		Spec: appsv1beta2.DeploymentSpec{
 			//add replicas
@@ -164,19 +230,19 @@ func NewDeployObject(component *v3.Component, app *v3.Application) appsv1beta2.D
 						"version": component.Version,
 						"inpool":  "yes",
 					},
+					Annotations: annotations,
 				},
 
 				Spec: corev1.PodSpec{
 					ImagePullSecrets: imagepullsecret,
+					InitContainers:   initContainers,
 					Containers:       containers,
 					Volumes:          volumes, //zk
 				},
 			},
 		},
 	}
-	deploy.Spec.Template.Spec.NodeSelector = make(map[string]string)
-	deploy.Spec.Template.Spec.NodeSelector["user"] = "SP"
-	deploy.Spec.Template.Spec.NodeSelector["type"] = "cpu"
+	deploy.Spec.Template.Spec.NodeSelector = getNodeSelector(component)
 	if len(app.Labels) != 0 {
 		for k, v := range app.Labels {
 			if k == "cattle.io/creator" {
@@ -185,22 +251,9 @@ func NewDeployObject(component *v3.Component, app *v3.Application) appsv1beta2.D
 			deploy.Spec.Template.Labels[k] = v
 		}
 	}
-	for _, i := range component.Containers {
-		if i.Resources.Gpu > 0 {
-			deploy.Spec.Template.Spec.NodeSelector["type"] = "GPU"
-			break
-		}
-	}
 
 	//if !reflect.DeepEqual(component.ComponentTraits.SchedulePolicy, v3.SchedulePolicy{}) {
 	if component.ComponentTraits.SchedulePolicy != nil {
-		if len(component.ComponentTraits.SchedulePolicy.NodeSelector) != 0 {
-			for k, v := range component.ComponentTraits.SchedulePolicy.NodeSelector {
-				if v != "" {
-					deploy.Spec.Template.Spec.NodeSelector[k] = v
-				}
-			}
-		}
 		//if !reflect.DeepEqual(component.ComponentTraits.SchedulePolicy.NodeAffinity, v3.CNodeAffinity{}) {
 		if component.ComponentTraits.SchedulePolicy.NodeAffinity != nil {
 			deploy.Spec.Template.Spec.Affinity = new(corev1.Affinity)
@@ -296,174 +349,117 @@ func NewDeployObject(component *v3.Component, app *v3.Application) appsv1beta2.D
 	}
 	if component.ComponentTraits.CustomMetric != nil {
 		if component.ComponentTraits.CustomMetric.Enable && component.ComponentTraits.CustomMetric.Uri != "" {
-			deploy.Spec.Template.Annotations = make(map[string]string)
 			deploy.Spec.Template.Annotations["prometheus.io/path"] = "/metrics"
 			deploy.Spec.Template.Annotations["prometheus.io/port"] = "16666"
 			deploy.Spec.Template.Annotations["prometheus.io/scrape"] = "true"
 		}
 	}
-	return deploy
+	return deploy, nil
 }
 
 func getContainers(component *v3.Component) ([]corev1.Container, error) {
 	var containers []corev1.Container
 	for _, cc := range component.Containers {
-		ports := getContainerPorts(cc)
-		envs := getContainerEnvs(cc)
-		resources := getContainerResources(cc)
-		livenesshandler, readinesshandler := getContainersHealthCheck(cc)
-		lifecycle := getContainersLifeCycle(cc)
-		var volumes []corev1.VolumeMount
-		for _, j := range cc.Resources.Volumes {
-			if j.Name == "" || j.MountPath == "" {
-				continue
-			}
-			volumes = append(volumes, corev1.VolumeMount{
-				Name:      component.Name + "-" + j.Name,
-				MountPath: j.MountPath,
-			})
+		if isInitContainer(component, cc.Name) {
+			continue
 		}
-		for _, k := range cc.Config {
-			if k.FileName == "" || k.Path == "" {
-				continue
-			}
-			volumes = append(volumes, corev1.VolumeMount{
-				Name:      component.Name + "-" + component.Version + "-" + strings.Replace(strings.Replace(k.FileName, ".", "-", -1), "_", "-", -1),
-				MountPath: strings.TrimSuffix(k.Path, "/") + "/" + k.FileName,
-				SubPath:   "path/to/" + k.FileName,
-			})
+		container, err := getContainer(component, cc)
+		if err != nil {
+			return nil, err
 		}
+		containers = append(containers, container)
+	}
 
-		container := corev1.Container{
-			Name:         cc.Name,
-			Image:        strings.Replace(cc.Image, "//", "/", -1),
-			Ports:        ports,
-			Env:          envs,
-			Resources:    resources,
-			VolumeMounts: volumes,
-		}
-		if len(cc.Command) != 0 {
-			var commandlist []string
-			for _, i := range cc.Command {
-				list := strings.Split(i, " ")
-				commandlist = append(commandlist, list...)
-			}
-			container.Command = commandlist
-		}
-		if len(cc.Args) != 0 {
-			var arglist []string
-			for _, i := range cc.Args {
-				list := strings.Split(i, " ")
-				arglist = append(arglist, list...)
-			}
-			container.Args = arglist
-		}
-		if lifecycle != nil {
-			container.Lifecycle = lifecycle
-		}
-		if !(reflect.DeepEqual(livenesshandler, corev1.Handler{})) {
-			container.LivenessProbe = &corev1.Probe{
-				InitialDelaySeconds: cc.LivenessProbe.InitialDelaySeconds,
-				TimeoutSeconds:      cc.LivenessProbe.TimeoutSeconds,
-				FailureThreshold:    cc.LivenessProbe.FailureThreshold,
-				Handler:             livenesshandler,
-			}
-		}
-		if !(reflect.DeepEqual(readinesshandler, corev1.Handler{})) {
-			container.ReadinessProbe = &corev1.Probe{
-				InitialDelaySeconds: cc.ReadinessProbe.InitialDelaySeconds,
-				PeriodSeconds:       cc.ReadinessProbe.PeriodSeconds,
-				TimeoutSeconds:      cc.ReadinessProbe.TimeoutSeconds,
-				Handler:             readinesshandler,
-			}
+	return containers, nil
+}
+
+// getContainer convert one container of component, regular and init containers are built the same way
+func getContainer(component *v3.Component, cc v3.ComponentContainer) (corev1.Container, error) {
+	ports := getContainerPorts(cc)
+	envs, envFrom, err := getContainerEnvs(cc)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	resources, err := getContainerResources(cc)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	livenessProbe, err := getProbe(cc.LivenessProbe)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("livenessProbe of container %s: %s", cc.Name, err.Error())
+	}
+	readinessProbe, err := getProbe(cc.ReadinessProbe)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("readinessProbe of container %s: %s", cc.Name, err.Error())
+	}
+	// slow starting containers are not killed by liveness checks before startupSeconds
+	startup, err := startupSeconds(component)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	if livenessProbe != nil && livenessProbe.InitialDelaySeconds < startup {
+		livenessProbe.InitialDelaySeconds = startup
+	}
+	lifecycle, err := getContainersLifeCycle(cc)
+	if err != nil {
+		return corev1.Container{}, err
+	}
+	var volumes []corev1.VolumeMount
+	for _, j := range cc.Resources.Volumes {
+		if j.Name == "" || j.MountPath == "" {
+			continue
 		}
-		containers = append(containers, container)
-		if component.ComponentTraits.CustomMetric != nil {
-			if component.ComponentTraits.CustomMetric.Enable && component.ComponentTraits.CustomMetric.Uri != "" {
-				resources := map[corev1.ResourceName]resource.Quantity{
-					corev1.ResourceCPU:    resource.MustParse("50m"),
-					corev1.ResourceMemory: resource.MustParse("50Mi"),
-				}
-				containers = append(containers, corev1.Container{
-					Name:            "transter-proxy",
-					Image:           os.Getenv("PROXYIMAGE"),
-					ImagePullPolicy: corev1.PullIfNotPresent,
-					Resources: corev1.ResourceRequirements{
-						Limits:   resources,
-						Requests: resources,
-					},
-					Env: []corev1.EnvVar{
-						{
-							Name: "POD_NAME",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									APIVersion: "v1",
-									FieldPath:  "metadata.name",
-								}},
-						},
-						{
-							Name: "POD_NAMESPACE",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									APIVersion: "v1",
-									FieldPath:  "metadata.namespace",
-								}},
-						},
-						{
-							Name: "POD_IP",
-							ValueFrom: &corev1.EnvVarSource{
-								FieldRef: &corev1.ObjectFieldSelector{
-									APIVersion: "v1",
-									FieldPath:  "status.podIP",
-								}},
-						},
-						{
-							Name:  "URI",
-							Value: component.ComponentTraits.CustomMetric.Uri,
-						},
-					},
-				})
-			}
+		volumes = append(volumes, corev1.VolumeMount{
+			Name:      component.Name + "-" + j.Name,
+			MountPath: j.MountPath,
+		})
+	}
+	for _, k := range cc.Config {
+		if !configMounted(k) {
+			continue
 		}
+		volumes = append(volumes, corev1.VolumeMount{
+			Name:      configVolumeName(component, k.FileName),
+			MountPath: strings.TrimSuffix(k.Path, "/") + "/" + k.FileName,
+			SubPath:   "path/to/" + k.FileName,
+		})
+	}
 
-		if component.ComponentTraits.Logcollect && os.Getenv("LOGCOLLECT_CONFIGMAP_NAME") != "" {
-			var logvolumes []corev1.VolumeMount
-			logvolumes = append(logvolumes, corev1.VolumeMount{
-				Name:      component.Name + "-" + "logdir",
-				MountPath: "/log",
-			}, corev1.VolumeMount{
-				Name:      component.Name + "-" + "log" + "-" + "configmap",
-				MountPath: "/fluentd/etc/",
-			})
-			resources := map[corev1.ResourceName]resource.Quantity{
-				corev1.ResourceCPU:    resource.MustParse("100m"),
-				corev1.ResourceMemory: resource.MustParse("200Mi"),
-			}
-			containers = append(containers, corev1.Container{
-				Name:            "custom-log-collect",
-				Image:           os.Getenv("LOGIMAGE"),
-				ImagePullPolicy: corev1.PullIfNotPresent,
-				Env: []corev1.EnvVar{
-					{
-						Name:  "FLUENTD_ARGS",
-						Value: "--no-supervisor -q",
-					},
-				},
-				Resources: corev1.ResourceRequirements{
-					Limits:   resources,
-					Requests: resources,
-				},
-				VolumeMounts: logvolumes,
-			})
+	container := corev1.Container{
+		Name:            cc.Name,
+		Image:           strings.Replace(cc.Image, "//", "/", -1),
+		ImagePullPolicy: corev1.PullPolicy(cc.ImagePullPolicy),
+		Ports:           ports,
+		EnvFrom:         envFrom,
+		Env:             envs,
+		Resources:       resources,
+		VolumeMounts:    volumes,
+	}
+	if len(cc.Command) != 0 {
+		commandlist, err := getCommand(cc.Command)
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("command of container %s: %s", cc.Name, err.Error())
 		}
+		container.Command = commandlist
 	}
-
-	return containers, nil
+	if len(cc.Args) != 0 {
+		arglist, err := getCommand(cc.Args)
+		if err != nil {
+			return corev1.Container{}, fmt.Errorf("args of container %s: %s", cc.Name, err.Error())
+		}
+		container.Args = arglist
+	}
+	if lifecycle != nil {
+		container.Lifecycle = lifecycle
+	}
+	container.LivenessProbe = livenessProbe
+	container.ReadinessProbe = readinessProbe
+	return container, nil
 }
 
-func getContainerResources(cc v3.ComponentContainer) corev1.ResourceRequirements {
-	cpu := "500m"
-	mem := "200Mi"
+func getContainerResources(cc v3.ComponentContainer) (corev1.ResourceRequirements, error) {
+	cpu := DefaultCPU
+	mem := DefaultMemory
 	if cc.Resources.Cpu != "" {
 		cpu = cc.Resources.Cpu
 	}
@@ -471,9 +467,17 @@ func getContainerResources(cc v3.ComponentContainer) corev1.ResourceRequirements
 	if cc.Resources.Memory != "" {
 		mem = cc.Resources.Memory
 	}
+	cpuQuantity, err := resource.ParseQuantity(cpu)
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("cpu %q of container %s is invalid: %s", cpu, cc.Name, err.Error())
+	}
+	memQuantity, err := resource.ParseQuantity(mem)
+	if err != nil {
+		return corev1.ResourceRequirements{}, fmt.Errorf("memory %q of container %s is invalid: %s", mem, cc.Name, err.Error())
+	}
 	resources := map[corev1.ResourceName]resource.Quantity{
-		corev1.ResourceCPU:    resource.MustParse(cpu),
-		corev1.ResourceMemory: resource.MustParse(mem),
+		corev1.ResourceCPU:    cpuQuantity,
+		corev1.ResourceMemory: memQuantity,
 	}
 	if cc.Resources.Gpu > 0 {
 		resources[corev1.ResourceName("nvidia.com/gpu")] = resource.MustParse(strconv.Itoa(cc.Resources.Gpu))
@@ -483,31 +487,7 @@ func getContainerResources(cc v3.ComponentContainer) corev1.ResourceRequirements
 		Limits:   resources,
 	}
 
-	return rr
-}
-
-func getContainerEnvs(cc v3.ComponentContainer) (envs []corev1.EnvVar) {
-	for _, ccenv := range cc.Env {
-		if ccenv.Name != "" && ccenv.FromParam != "" && (ccenv.FromParam == "spec.nodeName" || ccenv.FromParam == "metadata.name" || ccenv.FromParam == "metadata.namespace" || ccenv.FromParam == "status.podIP") {
-			env := corev1.EnvVar{
-				Name: ccenv.Name,
-				ValueFrom: &corev1.EnvVarSource{
-					FieldRef: &corev1.ObjectFieldSelector{
-						FieldPath: ccenv.FromParam,
-					}},
-			}
-			envs = append(envs, env)
-		} else if ccenv.Name != "" && ccenv.Value != "" {
-			env := corev1.EnvVar{
-				Name:  ccenv.Name,
-				Value: ccenv.Value,
-			}
-			envs = append(envs, env)
-		}
-
-	}
-
-	return envs
+	return rr, nil
 }
 
 func getContainerPorts(cc v3.ComponentContainer) []corev1.ContainerPort {
@@ -534,185 +514,25 @@ func getContainerPorts(cc v3.ComponentContainer) []corev1.ContainerPort {
 	return ports
 }
 
-// zk generate health check model data
-func getContainersHealthCheck(cc v3.ComponentContainer) (livenesshandler corev1.Handler, readinesshandler corev1.Handler) {
-	//log.Debugf("Container info is %v", cc)
-	//if !reflect.DeepEqual(cc.LivenessProbe, v3.HealthProbe{}) {
-	if cc.LivenessProbe != nil {
-		if cc.LivenessProbe.Exec != nil {
-			if len(cc.LivenessProbe.Exec.Command) != 0 {
-				var commandlist []string
-				for _, i := range cc.LivenessProbe.Exec.Command {
-					list := strings.Split(i, " ")
-					commandlist = append(commandlist, list...)
-				}
-				livenesshandler = corev1.Handler{
-					Exec: &corev1.ExecAction{
-						Command: commandlist,
-					},
-				}
-			}
-		} else if cc.LivenessProbe.HTTPGet != nil {
-			if cc.LivenessProbe.HTTPGet.Path != "" && cc.LivenessProbe.HTTPGet.Port > 0 {
-				livenesshandler = corev1.Handler{
-					HTTPGet: &corev1.HTTPGetAction{
-						Path: cc.LivenessProbe.HTTPGet.Path,
-						Port: intstr.IntOrString{
-							Type:   intstr.Int,
-							IntVal: int32(cc.LivenessProbe.HTTPGet.Port),
-						},
-					},
-				}
-			}
-		} else if cc.LivenessProbe.TCPSocket != nil {
-			if cc.LivenessProbe.TCPSocket.Port > 0 {
-				livenesshandler = corev1.Handler{
-					TCPSocket: &corev1.TCPSocketAction{
-						Port: intstr.IntOrString{
-							IntVal: int32(cc.LivenessProbe.TCPSocket.Port),
-							Type:   intstr.Int,
-						},
-					},
-				}
-			}
-		} else {
-			livenesshandler = corev1.Handler{}
-		}
-	}
-	//if !reflect.DeepEqual(cc.ReadinessProbe, v3.HealthProbe{}) {
-	if cc.ReadinessProbe != nil {
-		if cc.ReadinessProbe.Exec != nil {
-			if len(cc.ReadinessProbe.Exec.Command) != 0 {
-				var commandlist []string
-				for _, i := range cc.ReadinessProbe.Exec.Command {
-					list := strings.Split(i, " ")
-					commandlist = append(commandlist, list...)
-				}
-				readinesshandler = corev1.Handler{
-					Exec: &corev1.ExecAction{
-						Command: commandlist,
-					},
-				}
-			}
-		} else if cc.ReadinessProbe.HTTPGet != nil {
-			if cc.ReadinessProbe.HTTPGet.Path != "" && cc.ReadinessProbe.HTTPGet.Port > 0 {
-				readinesshandler = corev1.Handler{
-					HTTPGet: &corev1.HTTPGetAction{
-						Path: cc.ReadinessProbe.HTTPGet.Path,
-						Port: intstr.IntOrString{
-							Type:   intstr.Int,
-							IntVal: int32(cc.ReadinessProbe.HTTPGet.Port),
-						},
-					},
-				}
-			}
-		} else if cc.ReadinessProbe.TCPSocket != nil {
-			if cc.ReadinessProbe.TCPSocket.Port > 0 {
-				readinesshandler = corev1.Handler{
-					TCPSocket: &corev1.TCPSocketAction{
-						Port: intstr.IntOrString{
-							IntVal: int32(cc.ReadinessProbe.TCPSocket.Port),
-							Type:   intstr.Int,
-						},
-					},
-				}
-			}
-		} else {
-			readinesshandler = corev1.Handler{}
-		}
-	}
-	return
-}
-
 // zk generate pod lifecycle
-func getContainersLifeCycle(cc v3.ComponentContainer) (lifecycle *corev1.Lifecycle) {
+func getContainersLifeCycle(cc v3.ComponentContainer) (lifecycle *corev1.Lifecycle, err error) {
 	//if reflect.DeepEqual(cc.Lifecycle, v3.CLifecycle{}) {
 	if cc.Lifecycle == nil {
-		return nil
+		return nil, nil
 	}
 	// new lifecycle memory address
 	lifecycle = new(corev1.Lifecycle)
 	log.Debugf("Container info is %v", cc)
 	if cc.Lifecycle.PostStart != nil {
-		if cc.Lifecycle.PostStart.Exec != nil {
-			if len(cc.Lifecycle.PostStart.Exec.Command) != 0 {
-				var commandlist []string
-				for _, i := range cc.Lifecycle.PostStart.Exec.Command {
-					list := strings.Split(i, " ")
-					commandlist = append(commandlist, list...)
-				}
-				lifecycle.PostStart = &corev1.Handler{
-					Exec: &corev1.ExecAction{
-						Command: commandlist,
-					},
-				}
-			}
-		}
-		if cc.Lifecycle.PostStart.HTTPGet != nil {
-			if cc.Lifecycle.PostStart.HTTPGet.Path != "" && cc.Lifecycle.PostStart.HTTPGet.Port > 0 {
-				lifecycle.PostStart = &corev1.Handler{
-					HTTPGet: &corev1.HTTPGetAction{
-						Path: cc.Lifecycle.PostStart.HTTPGet.Path,
-						Port: intstr.IntOrString{
-							Type:   intstr.Int,
-							IntVal: int32(cc.Lifecycle.PostStart.HTTPGet.Port),
-						},
-					},
-				}
-			}
-		}
-		if cc.Lifecycle.PostStart.TCPSocket != nil {
-			if cc.Lifecycle.PostStart.TCPSocket.Port > 0 {
-				lifecycle.PostStart = &corev1.Handler{
-					TCPSocket: &corev1.TCPSocketAction{
-						Port: intstr.IntOrString{
-							IntVal: int32(cc.Lifecycle.PostStart.TCPSocket.Port),
-							Type:   intstr.Int,
-						},
-					},
-				}
-			}
+		lifecycle.PostStart, err = getHandler(cc.Lifecycle.PostStart)
+		if err != nil {
+			return nil, fmt.Errorf("lifecycle postStart of container %s: %s", cc.Name, err.Error())
 		}
 	}
 	if cc.Lifecycle.PreStop != nil {
-		if cc.Lifecycle.PreStop.Exec != nil {
-			if len(cc.Lifecycle.PreStop.Exec.Command) != 0 {
-				var commandlist []string
-				for _, i := range cc.Lifecycle.PreStop.Exec.Command {
-					list := strings.Split(i, " ")
-					commandlist = append(commandlist, list...)
-				}
-				lifecycle.PreStop = &corev1.Handler{
-					Exec: &corev1.ExecAction{
-						Command: commandlist,
-					},
-				}
-			}
-		}
-		if cc.Lifecycle.PreStop.HTTPGet != nil {
-			if cc.Lifecycle.PreStop.HTTPGet.Path != "" && cc.Lifecycle.PreStop.HTTPGet.Port > 0 {
-				lifecycle.PreStop = &corev1.Handler{
-					HTTPGet: &corev1.HTTPGetAction{
-						Path: cc.Lifecycle.PreStop.HTTPGet.Path,
-						Port: intstr.IntOrString{
-							Type:   intstr.Int,
-							IntVal: int32(cc.Lifecycle.PreStop.HTTPGet.Port),
-						},
-					},
-				}
-			}
-		}
-		if cc.Lifecycle.PreStop.TCPSocket != nil {
-			if cc.Lifecycle.PreStop.TCPSocket.Port > 0 {
-				lifecycle.PreStop = &corev1.Handler{
-					TCPSocket: &corev1.TCPSocketAction{
-						Port: intstr.IntOrString{
-							IntVal: int32(cc.Lifecycle.PreStop.TCPSocket.Port),
-							Type:   intstr.Int,
-						},
-					},
-				}
-			}
+		lifecycle.PreStop, err = getHandler(cc.Lifecycle.PreStop)
+		if err != nil {
+			return nil, fmt.Errorf("lifecycle preStop of container %s: %s", cc.Name, err.Error())
 		}
 	}
 	return
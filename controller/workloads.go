@@ -0,0 +1,172 @@
+package controller
+
+import (
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workloadClient read and delete one kind of workload a component may be rendered as.
+// The status only records the workload name, so lookups try every kind.
+type workloadClient struct {
+	kind   string
+	get    func(namespace, name string) (metav1.Object, error)
+	delete func(namespace, name string, options *metav1.DeleteOptions) error
+}
+
+func (c *controller) workloadClients() []workloadClient {
+	return []workloadClient{
+		{
+			kind: DeploymentKind.Kind,
+			get: func(namespace, name string) (metav1.Object, error) {
+				return c.deploymentLister.Get(namespace, name)
+			},
+			delete: c.deploymentClient.DeleteNamespaced,
+		},
+		{
+			kind: StatefulSetKind.Kind,
+			get: func(namespace, name string) (metav1.Object, error) {
+				return c.statefulSetLister.Get(namespace, name)
+			},
+			delete: c.statefulSetClient.DeleteNamespaced,
+		},
+		{
+			kind: DaemonSetKind.Kind,
+			get: func(namespace, name string) (metav1.Object, error) {
+				return c.daemonSetLister.Get(namespace, name)
+			},
+			delete: c.daemonSetClient.DeleteNamespaced,
+		},
+		{
+			kind: JobKind.Kind,
+			get: func(namespace, name string) (metav1.Object, error) {
+				return c.jobLister.Get(namespace, name)
+			},
+			delete: c.jobClient.DeleteNamespaced,
+		},
+		{
+			kind: CronJobKind.Kind,
+			get: func(namespace, name string) (metav1.Object, error) {
+				return c.cronJobLister.Get(namespace, name)
+			},
+			delete: c.cronJobClient.DeleteNamespaced,
+		},
+	}
+}
+
+// getWorkload find the workload called name, NotFound when no kind has it
+func (c *controller) getWorkload(namespace, name string) (string, metav1.Object, error) {
+	for _, client := range c.workloadClients() {
+		object, err := client.get(namespace, name)
+		if err == nil {
+			return client.kind, object, nil
+		}
+		if !errors.IsNotFound(err) {
+			return "", nil, err
+		}
+	}
+	return "", nil, errors.NewNotFound(appsv1beta2.Resource("workload"), name)
+}
+
+// getWorkloadOfKind get the workload of kind called name
+func (c *controller) getWorkloadOfKind(namespace, kind, name string) (metav1.Object, error) {
+	for _, client := range c.workloadClients() {
+		if client.kind == kind {
+			return client.get(namespace, name)
+		}
+	}
+	return nil, errors.NewNotFound(appsv1beta2.Resource("workload"), name)
+}
+
+// workloadKind report whether kind is one a component may be rendered as
+func workloadKind(kind string) bool {
+	switch kind {
+	case DeploymentKind.Kind, StatefulSetKind.Kind, DaemonSetKind.Kind, JobKind.Kind, CronJobKind.Kind:
+		return true
+	}
+	return false
+}
+
+// deleteWorkload delete the workload called name of every kind, return the deleted kind,
+// empty when nothing exists
+func (c *controller) deleteWorkload(namespace, name string) (string, error) {
+	deletePolicy := metav1.DeletePropagationBackground
+	for _, client := range c.workloadClients() {
+		if _, err := client.get(namespace, name); errors.IsNotFound(err) {
+			continue
+		}
+		err := client.delete(namespace, name, &metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+		})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		return client.kind, err
+	}
+	return "", nil
+}
+
+// deleteReplacedWorkloads delete the workloads called name of other kinds owned by app,
+// left behind when the workloadType of a component version changes
+func (c *controller) deleteReplacedWorkloads(app *v3.Application, name, kind string) error {
+	deletePolicy := metav1.DeletePropagationBackground
+	for _, client := range c.workloadClients() {
+		if client.kind == kind {
+			continue
+		}
+		object, err := client.get(app.Namespace, name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if ref := metav1.GetControllerOf(object); ref == nil || ref.UID != app.UID {
+			continue
+		}
+		err = client.delete(app.Namespace, name, &metav1.DeleteOptions{
+			PropagationPolicy: &deletePolicy,
+		})
+		c.recordEvent(app, ActionDelete, client.kind, name, err)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// workloadRollout rollout state, label selector and desired replicas of a workload
+func workloadRollout(object metav1.Object) (rolling, stuck bool, reason, message string, selector *metav1.LabelSelector, desired int32) {
+	desired = 1
+	switch workload := object.(type) {
+	case *appsv1beta2.Deployment:
+		rolling, stuck, reason, message = deploymentRolloutStatus(workload)
+		selector = workload.Spec.Selector
+		if workload.Spec.Replicas != nil {
+			desired = *workload.Spec.Replicas
+		}
+	case *appsv1beta2.StatefulSet:
+		rolling, reason, message = statefulSetRolloutStatus(workload)
+		selector = workload.Spec.Selector
+		if workload.Spec.Replicas != nil {
+			desired = *workload.Spec.Replicas
+		}
+	case *appsv1beta2.DaemonSet:
+		rolling, reason, message = daemonSetRolloutStatus(workload)
+		selector = workload.Spec.Selector
+		desired = workload.Status.DesiredNumberScheduled
+	case *batchv1.Job:
+		// a finished job has no pods to wait for
+		rolling, stuck, reason, message = jobRolloutStatus(workload)
+		desired = 0
+	case *batchv1beta1.CronJob:
+		rolling, reason, desired = false, "Scheduled", 0
+		if workload.Spec.Suspend != nil && *workload.Spec.Suspend {
+			reason = "Suspended"
+		}
+	}
+	return
+}
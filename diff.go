@@ -0,0 +1,354 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/hd-Li/application/controller"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	"github.com/pmezard/go-difflib/difflib"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// renderedKinds every kind Render may generate, used to find orphaned objects
+var renderedKinds = []schema.GroupVersionKind{
+	controller.ConfigMapKind,
+	controller.SecretKind,
+	controller.PersistentVolumeClaimKind,
+	controller.DeploymentKind,
+	controller.StatefulSetKind,
+	controller.DaemonSetKind,
+	controller.JobKind,
+	controller.CronJobKind,
+	controller.HorizontalPodAutoscalerKind,
+	controller.ServiceKind,
+	controller.ServiceRoleKind,
+	controller.ServiceRoleBindingKind,
+	controller.VirtualServiceKind,
+	controller.DestinationRuleKind,
+	controller.InstanceKind,
+	controller.QuotaSpecKind,
+	controller.QuotaSpecBindingKind,
+	controller.HandlerKind,
+	controller.RuleKind,
+}
+
+// runDiff implement `application diff -f app.yaml`, render the applications locally and
+// print a unified diff against the live objects. Exit code is 0 without differences,
+// 1 when something differs and 2 on error, same as diff(1).
+func runDiff(args []string) {
+	log.SetOutput(os.Stderr)
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	file := flags.String("f", "", "application manifest to diff, - for stdin")
+	kubeconfig := flags.String("kubeconfig", os.Getenv("KUBECONFIG"), "kubeconfig file, in-cluster config is used when empty")
+	sidecars := flags.String("sidecars", "", "sidecar registry ConfigMap manifest, only the built-in sidecars when empty")
+	flags.Parse(args)
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "usage: application diff -f app.yaml [-kubeconfig config] [-sidecars registry.yaml]")
+		os.Exit(2)
+	}
+	if err := loadSidecars(*sidecars); err != nil {
+		log.Errorf("Load sidecar registry %s failed, err: %s", *sidecars, err.Error())
+		os.Exit(2)
+	}
+
+	apps, err := readApplications(*file)
+	if err != nil {
+		log.Errorf("Read %s failed, err: %s", *file, err.Error())
+		os.Exit(2)
+	}
+	restConfig, err := loadRestConfig(*kubeconfig)
+	if err != nil {
+		log.Errorf("Get restconfig failed: %s", err.Error())
+		os.Exit(2)
+	}
+	differ, err := newLiveDiffer(restConfig)
+	if err != nil {
+		log.Errorf("Create client failed: %s", err.Error())
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	changed := false
+	for _, app := range apps {
+		c, err := differ.diff(out, app)
+		if err != nil {
+			out.Flush()
+			log.Errorf("Diff application %s Error : %s", app.Namespace+":"+app.Name, err.Error())
+			os.Exit(2)
+		}
+		changed = changed || c
+	}
+	if changed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+// loadRestConfig use kubeconfig when given, otherwise in-cluster config
+func loadRestConfig(kubeconfig string) (*rest.Config, error) {
+	if kubeconfig != "" {
+		return clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return clientcmd.BuildConfigFromFlags("", kubeConfig)
+	}
+	return restConfig, nil
+}
+
+type liveDiffer struct {
+	client dynamic.Interface
+	mapper meta.RESTMapper
+}
+
+func newLiveDiffer(restConfig *rest.Config) (*liveDiffer, error) {
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+	groupResources, err := restmapper.GetAPIGroupResources(discoveryClient)
+	if err != nil {
+		return nil, err
+	}
+	return &liveDiffer{
+		client: client,
+		mapper: restmapper.NewDiscoveryRESTMapper(groupResources),
+	}, nil
+}
+
+func (d *liveDiffer) resource(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := d.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	return d.client.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// diff print the diff of every rendered object of app and the orphaned ones, report whether anything differs
+func (d *liveDiffer) diff(out *bufio.Writer, app *v3.Application) (bool, error) {
+	objects, err := controller.Render(app)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	rendered := make(map[string]bool)
+	for _, object := range objects {
+		gvk := object.GetObjectKind().GroupVersionKind()
+		desired, err := toUnstructured(object)
+		if err != nil {
+			return false, err
+		}
+		rendered[gvk.Kind+"/"+desired.GetName()] = true
+		path := gvk.Kind + "/" + desired.GetNamespace() + "/" + desired.GetName()
+
+		resource, err := d.resource(gvk, desired.GetNamespace())
+		if err != nil {
+			return false, err
+		}
+		live, err := resource.Get(desired.GetName(), metav1.GetOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return false, err
+		}
+		if live == nil || errors.IsNotFound(err) {
+			changed = true
+			fmt.Fprintf(out, "# %s not found in cluster, controller will create it\n", path)
+			writeDiff(out, path, nil, desired)
+			continue
+		}
+
+		applied := live.GetAnnotations()[controller.LastAppliedConfigAnnotation] == desired.GetAnnotations()[controller.LastAppliedConfigAnnotation]
+		liveText, desiredText, err := diffText(live, desired)
+		if err != nil {
+			return false, err
+		}
+		if applied && liveText == desiredText {
+			continue
+		}
+		changed = true
+		if applied {
+			fmt.Fprintf(out, "# %s last-applied-configuration matches, live object drifted\n", path)
+		} else {
+			fmt.Fprintf(out, "# %s last-applied-configuration differs, controller will update it\n", path)
+		}
+		writeText(out, path, liveText, desiredText)
+	}
+
+	orphans, err := d.orphans(app, rendered)
+	if err != nil {
+		return false, err
+	}
+	for _, orphan := range orphans {
+		changed = true
+		fmt.Fprintf(out, "# %s exists in cluster but is no longer generated\n", orphan)
+	}
+	return changed, nil
+}
+
+// orphans list objects named and owned like the ones of app which Render did not generate
+func (d *liveDiffer) orphans(app *v3.Application, rendered map[string]bool) ([]string, error) {
+	var orphans []string
+	prefix := app.Name + "-"
+	for _, gvk := range renderedKinds {
+		resource, err := d.resource(gvk, app.Namespace)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				// istio crds not installed
+				continue
+			}
+			return nil, err
+		}
+		list, err := resource.List(metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			if !strings.HasPrefix(item.GetName(), prefix) || rendered[gvk.Kind+"/"+item.GetName()] {
+				continue
+			}
+			for _, ref := range item.GetOwnerReferences() {
+				if (ref.Kind == "Application" && ref.Name == app.Name) || ((ref.Kind == "Deployment" || ref.Kind == "StatefulSet" || ref.Kind == "DaemonSet") && strings.HasPrefix(ref.Name, prefix)) {
+					orphans = append(orphans, gvk.Kind+"/"+item.GetNamespace()+"/"+item.GetName())
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+func toUnstructured(object runtime.Object) (*unstructured.Unstructured, error) {
+	data, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	u := new(unstructured.Unstructured)
+	err = json.Unmarshal(data, &u.Object)
+	return u, err
+}
+
+// diffText render both objects as yaml for comparison. Fields maintained by the apiserver,
+// status and the last-applied annotation are dropped, and the live object is pruned to the
+// fields the controller generates, so that defaulted values do not show up as differences.
+func diffText(live, desired *unstructured.Unstructured) (string, string, error) {
+	l := cleanObject(live.Object)
+	r := cleanObject(desired.Object)
+	l = prune(l, r).(map[string]interface{})
+	lt, err := yaml.Marshal(l)
+	if err != nil {
+		return "", "", err
+	}
+	rt, err := yaml.Marshal(r)
+	if err != nil {
+		return "", "", err
+	}
+	return string(lt), string(rt), nil
+}
+
+func cleanObject(object map[string]interface{}) map[string]interface{} {
+	object = runtime.DeepCopyJSON(object)
+	delete(object, "status")
+	metadata, _ := object["metadata"].(map[string]interface{})
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "selfLink", "managedFields"} {
+		delete(metadata, field)
+	}
+	// secret data is shown as its checksum, credentials are not printed
+	if data, ok := object["data"].(map[string]interface{}); ok && object["kind"] == controller.SecretKind.Kind {
+		for k, v := range data {
+			value, _ := v.(string)
+			decoded, _ := base64.StdEncoding.DecodeString(value)
+			data[k] = "sha256:" + controller.GetDataChecksum(map[string][]byte{k: decoded})
+		}
+	}
+	if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+		delete(annotations, controller.LastAppliedConfigAnnotation)
+		if len(annotations) == 0 {
+			delete(metadata, "annotations")
+		}
+	}
+	if refs, ok := metadata["ownerReferences"].([]interface{}); ok {
+		for _, ref := range refs {
+			if m, ok := ref.(map[string]interface{}); ok {
+				delete(m, "uid")
+			}
+		}
+	}
+	return object
+}
+
+// prune keep only the parts of live which also exist in desired
+func prune(live, desired interface{}) interface{} {
+	switch d := desired.(type) {
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			return live
+		}
+		result := make(map[string]interface{})
+		for k, v := range l {
+			if dv, ok := d[k]; ok {
+				result[k] = prune(v, dv)
+			}
+		}
+		return result
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok || len(l) != len(d) {
+			return live
+		}
+		result := make([]interface{}, len(l))
+		for i := range l {
+			result[i] = prune(l[i], d[i])
+		}
+		return result
+	}
+	return live
+}
+
+func writeDiff(out *bufio.Writer, path string, live, desired *unstructured.Unstructured) {
+	var liveText, desiredText string
+	if live != nil {
+		data, _ := yaml.Marshal(cleanObject(live.Object))
+		liveText = string(data)
+	}
+	if desired != nil {
+		data, _ := yaml.Marshal(cleanObject(desired.Object))
+		desiredText = string(data)
+	}
+	writeText(out, path, liveText, desiredText)
+}
+
+func writeText(out *bufio.Writer, path, liveText, desiredText string) {
+	text, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(liveText),
+		B:        difflib.SplitLines(desiredText),
+		FromFile: "live/" + path,
+		ToFile:   "rendered/" + path,
+		Context:  3,
+	})
+	out.WriteString(text)
+}
@@ -8,10 +8,14 @@ require (
 	github.com/docker/docker v1.13.1 // indirect
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.4.0 // indirect
+	github.com/evanphx/json-patch v4.2.0+incompatible
+	github.com/ghodss/yaml v1.0.0
+	github.com/google/gofuzz v1.0.0
 	github.com/hd-Li/types v0.0.0-20200108072342-40227b4a545d
 	github.com/knative/pkg v0.0.0-20190817231834-12ee58e32cc8
 	github.com/kylelemons/godebug v1.1.0 // indirect
 	github.com/mattn/go-colorable v0.1.6
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/prometheus/common v0.9.1
 	github.com/rancher/norman v0.0.0-20190319175355-e10534b012b0
 	github.com/sirupsen/logrus v1.4.2
@@ -19,6 +23,7 @@ require (
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v2 v2.2.4
 	k8s.io/api v0.0.0-20190409021203-6e4e0e4f393b
+	k8s.io/apiextensions-apiserver v0.0.0-20190409022649-727a075fdec8
 	k8s.io/apimachinery v0.0.0-20190404173353-6a84e37a896d
 	k8s.io/client-go v11.0.1-0.20190409021438-1a26190bd76a+incompatible
 	k8s.io/kubernetes v0.0.0-00010101000000-000000000000
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	defaultLeaseName      string = "application-controller"
+	defaultLeaseNamespace string = "application"
+
+	// a standby replica takes over leaseDuration + retryPeriod after the leader stopped renewing, 17s
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// LeaderElectionConfig describe where the lease lives and who we are
+type LeaderElectionConfig struct {
+	Name      string
+	Namespace string
+	Identity  string
+}
+
+// NewLeaderElectionConfig read lease settings from env
+// LEADER_ELECTION_NAME 默认 application-controller
+// LEADER_ELECTION_NAMESPACE 默认 POD_NAMESPACE, 未设置时为 application
+// POD_NAME 作为身份标识, 未设置时使用 hostname
+func NewLeaderElectionConfig() (LeaderElectionConfig, error) {
+	cfg := LeaderElectionConfig{
+		Name:      os.Getenv("LEADER_ELECTION_NAME"),
+		Namespace: os.Getenv("LEADER_ELECTION_NAMESPACE"),
+		Identity:  os.Getenv("POD_NAME"),
+	}
+	if cfg.Name == "" {
+		cfg.Name = defaultLeaseName
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = os.Getenv("POD_NAMESPACE")
+	}
+	if cfg.Namespace == "" {
+		cfg.Namespace = defaultLeaseNamespace
+	}
+	if cfg.Identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Identity = hostname
+	}
+	return cfg, nil
+}
+
+// RunLeaderElection block until ctx is done, run is called once this replica hold the lease.
+// When ctx is cancelled (SIGTERM) the lease is not given up: the elector of this client-go measures the
+// expiry from the moment a standby observes the record change, so rewriting the record only restarts its
+// clock. A standby takes over at most leaseDuration plus retryPeriod after the last renewal.
+// Losing the lease for any other reason exits the process.
+func RunLeaderElection(ctx context.Context, client kubernetes.Interface, cfg LeaderElectionConfig, run func(context.Context)) {
+	lock := &resourcelock.ConfigMapLock{
+		ConfigMapMeta: metav1.ObjectMeta{
+			Namespace: cfg.Namespace,
+			Name:      cfg.Name,
+		},
+		Client: client.CoreV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity:      cfg.Identity,
+			EventRecorder: newLeaderRecorder(client, cfg.Name),
+		},
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Infof("%s acquired lease %s", cfg.Identity, lock.Describe())
+				run(ctx)
+			},
+			OnStoppedLeading: func() {
+				if ctx.Err() != nil {
+					return
+				}
+				log.Fatalf("%s lost lease %s", cfg.Identity, lock.Describe())
+			},
+			OnNewLeader: func(identity string) {
+				if identity != "" && identity != cfg.Identity {
+					log.Infof("Current leader is %s, %s is on standby", identity, cfg.Identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		log.Fatalf("Create leader elector failed, err: %s", err.Error())
+	}
+
+	log.Infof("%s waiting for lease %s", cfg.Identity, lock.Describe())
+	elector.Run(ctx)
+}
+
+func newLeaderRecorder(client kubernetes.Interface, name string) record.EventRecorder {
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(log.Debugf)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: name})
+}
@@ -9,12 +9,12 @@ import (
 	log "github.com/sirupsen/logrus"
 
 	typesconfig "github.com/hd-Li/types/config"
-	//"github.com/rancher/norman/leader"
+	normancontroller "github.com/rancher/norman/controller"
 	"github.com/rancher/norman/store/crd"
 	"github.com/rancher/norman/store/proxy"
 	"github.com/snowzach/rotatefilehook"
+	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 
 	//"github.com/rancher/norman/types"
 	"github.com/hd-Li/application/controller"
@@ -27,7 +27,8 @@ var (
 	kubeConfig string = "./config142"
 )
 
-func init() {
+// setupController check env and log settings of the controller, subcommands do not need them
+func setupController() {
 	if os.Getenv("REDIS_SERVER") == "" || os.Getenv("AUTHN_ENDPOINT") == "" || os.Getenv("AUTHN_REALM") == "" || os.Getenv("PROXYIMAGE") == "" {
 		log.Fatalf("Please check env settings (%s %s %s %s)", "REDIS_SERVER", "AUTHN_ENDPOINT", "AUTHN_REALM", "PROXYIMAGE")
 	}
@@ -70,14 +71,23 @@ func init() {
 }
 
 func main() {
+	// 子命令 离线使用 不连接集群
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			runRender(os.Args[2:])
+			return
+		case "diff":
+			runDiff(os.Args[2:])
+			return
+		}
+	}
+	setupController()
 	// 初始化kubeconfig
-	restConfig, err := rest.InClusterConfig()
+	restConfig, err := loadRestConfig("")
 	if err != nil {
-		restConfig, err = clientcmd.BuildConfigFromFlags("", kubeConfig)
-		if err != nil {
-			log.Fatalf("Get restconfig failed: %s", err.Error())
-			os.Exit(1)
-		}
+		log.Fatalf("Get restconfig failed: %s", err.Error())
+		os.Exit(1)
 	}
 
 	ctx := SigTermCancelContext(context.Background())
@@ -87,35 +97,48 @@ func main() {
 		log.Fatalf("create userContext failed, err: %s", err.Error())
 		os.Exit(1)
 	}
+	// 准入webhook 不依赖leader 所有副本均提供服务
+	RunWebhook(ctx)
+	leaderConfig, err := NewLeaderElectionConfig()
+	if err != nil {
+		log.Fatalf("load leader election config failed, err: %s", err.Error())
+		os.Exit(1)
+	}
 	// 连接apiserver 创建Application CRD资源
 	err = SetupApplicationCRD(ctx, userContext, *restConfig)
 	if err != nil {
 		log.Fatalf("create application crd failed, err: %s ", err.Error())
 		os.Exit(1)
 	}
-	// 注册userContext
+	// 注册userContext 此时只注册handler, worker在当选leader后才启动
 	controller.Register(ctx, userContext)
-	// 启动控制器
-	err = userContext.Start(ctx)
+	// 备用副本同样同步informer缓存, 接管时无需重新list
+	err = SyncCaches(ctx, userContext)
 	if err != nil {
-		panic(err)
+		log.Fatalf("sync caches failed, err: %s", err.Error())
+		os.Exit(1)
 	}
-	<-ctx.Done()
-	/*go leader.RunOrDie(ctx, "", "application-controller", userContext.K8sClient, func(ctx context.Context) {
-		err = SetupApplicationCRD(ctx, userContext, *restConfig)
-		if err != nil {
-			log.Fatalf("create application crd failed, err: %s ", err.Error())
-			os.Exit(1)
-		}
-
-		controller.Register(ctx, userContext)
-		err = userContext.Start(ctx)
+	RunLeaderElection(ctx, userContext.K8sClient, leaderConfig, func(ctx context.Context) {
+		// 当选leader 启动控制器
+		err := userContext.Start(ctx)
 		if err != nil {
 			panic(err)
 		}
-		<-ctx.Done()
 	})
-	<-ctx.Done()*/
+}
+
+// SyncCaches use for fill informer caches without starting workers
+func SyncCaches(ctx context.Context, userContext *typesconfig.UserOnlyContext) error {
+	return normancontroller.Sync(ctx,
+		userContext.Apps,
+		userContext.Project,
+		userContext.Core,
+		userContext.Autoscaling,
+		userContext.IstioAuthn,
+		userContext.IstioNetworking,
+		userContext.IstioRbac,
+		userContext.IstioConfig,
+	)
 }
 
 // SetupApplicationCRD use for init application crd
@@ -132,14 +155,37 @@ func SetupApplicationCRD(ctx context.Context, apiContext *typesconfig.UserOnlyCo
 	}
 
 	factory := &crd.Factory{ClientGetter: clientGetter}
-	_, err = factory.CreateCRDs(ctx, typesconfig.UserStorageContext, applicationschema)
+	crds, err := factory.CreateCRDs(ctx, typesconfig.UserStorageContext, applicationschema)
+	if err != nil {
+		return err
+	}
 
-	return err
+	// status 走 status 子资源, spec 未变化时 generation 不变
+	apiClient, err := clientGetter.APIExtClient(nil, typesconfig.UserStorageContext)
+	if err != nil {
+		return err
+	}
+	for _, object := range crds {
+		if object.Spec.Subresources != nil && object.Spec.Subresources.Status != nil {
+			continue
+		}
+		object = object.DeepCopy()
+		if object.Spec.Subresources == nil {
+			object.Spec.Subresources = &apiextv1beta1.CustomResourceSubresources{}
+		}
+		object.Spec.Subresources.Status = &apiextv1beta1.CustomResourceSubresourceStatus{}
+		log.Infof("Enable status subresource for crd %s", object.Name)
+		_, err = apiClient.ApiextensionsV1beta1().CustomResourceDefinitions().Update(object)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // SigTermCancelContext use for kill process
 func SigTermCancelContext(ctx context.Context) context.Context {
-	term := make(chan os.Signal)
+	term := make(chan os.Signal, 1)
 	signal.Notify(term, os.Interrupt, syscall.SIGTERM)
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/hd-Li/application/controller"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// runRender implement `application render -f app.yaml`,
+// print every manifest generated from the applications in the file as multi-document yaml
+func runRender(args []string) {
+	log.SetOutput(os.Stderr)
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	file := flags.String("f", "", "application manifest to render, - for stdin")
+	sidecars := flags.String("sidecars", "", "sidecar registry ConfigMap manifest, only the built-in sidecars when empty")
+	flags.Parse(args)
+	if *file == "" {
+		fmt.Fprintln(os.Stderr, "usage: application render -f app.yaml [-sidecars registry.yaml]")
+		os.Exit(2)
+	}
+	if err := loadSidecars(*sidecars); err != nil {
+		log.Fatalf("Load sidecar registry %s failed, err: %s", *sidecars, err.Error())
+	}
+
+	apps, err := readApplications(*file)
+	if err != nil {
+		log.Fatalf("Read %s failed, err: %s", *file, err.Error())
+	}
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	failed := false
+	for _, app := range apps {
+		objects, err := controller.Render(app)
+		if err != nil {
+			log.Errorf("Render application %s Error : %s", app.Namespace+":"+app.Name, err.Error())
+			failed = true
+		}
+		for _, object := range objects {
+			data, err := yaml.Marshal(object)
+			if err != nil {
+				log.Fatalf("Marshal %s failed, err: %s", object.GetObjectKind().GroupVersionKind().Kind, err.Error())
+			}
+			fmt.Fprintln(out, "---")
+			out.Write(data)
+		}
+	}
+	if failed {
+		out.Flush()
+		os.Exit(1)
+	}
+}
+
+// readApplications decode all applications of a yaml or json file, documents separated by ---
+func readApplications(file string) ([]*v3.Application, error) {
+	var reader io.Reader = os.Stdin
+	if file != "-" {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		reader = f
+	}
+
+	var apps []*v3.Application
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		app := new(v3.Application)
+		err := decoder.Decode(app)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if app.Name == "" {
+			// empty document
+			continue
+		}
+		if app.Kind != "" && app.Kind != "Application" {
+			return nil, fmt.Errorf("%s %s is not an Application", app.Kind, app.Name)
+		}
+		if app.Namespace == "" {
+			app.Namespace = "default"
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// loadSidecars fill the sidecar registry from the ConfigMap manifest in file, as the controller does from the cluster
+func loadSidecars(file string) error {
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	configmap := new(corev1.ConfigMap)
+	if err := yaml.Unmarshal(data, configmap); err != nil {
+		return err
+	}
+	return controller.SetSidecarTemplates(configmap.Data, file)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hd-Li/application/controller"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	log "github.com/sirupsen/logrus"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// admitFunc review one application, a nil response allows it unchanged
+type admitFunc func(app *v3.Application, request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse
+
+// RunWebhook serve the validating (/validate) and defaulting (/mutate) webhooks over https when WEBHOOK_CERT_FILE and WEBHOOK_KEY_FILE are set
+// and exist.
+// It runs on every replica, the apiserver may call any of them.
+func RunWebhook(ctx context.Context) {
+	certFile := os.Getenv("WEBHOOK_CERT_FILE")
+	keyFile := os.Getenv("WEBHOOK_KEY_FILE")
+	if certFile == "" || keyFile == "" {
+		log.Infoln("WEBHOOK_CERT_FILE or WEBHOOK_KEY_FILE not set, admission webhook disabled")
+		return
+	}
+	// the certificate secret is optional, webhook.yaml and scripts/webhook-certs enable the webhook
+	for _, file := range []string{certFile, keyFile} {
+		if _, err := os.Stat(file); err != nil {
+			log.Infof("Webhook certificate %s not available, admission webhook disabled: %s", file, err.Error())
+			return
+		}
+	}
+	port := os.Getenv("WEBHOOK_PORT")
+	if port == "" {
+		port = "8443"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/validate", serveAdmission(validateApplication))
+	mux.HandleFunc("/mutate", serveAdmission(defaultApplication))
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	go func() {
+		log.Infof("Admission webhook listening on :%s", port)
+		err := server.ListenAndServeTLS(certFile, keyFile)
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Admission webhook failed, err: %s", err.Error())
+		}
+	}()
+}
+
+// serveAdmission decode the AdmissionReview, call admit for applications and write the response back
+func serveAdmission(admit admitFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+			http.Error(w, fmt.Sprintf("unsupported content type %s", contentType), http.StatusUnsupportedMediaType)
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		review := new(admissionv1beta1.AdmissionReview)
+		err = json.Unmarshal(body, review)
+		if err != nil || review.Request == nil {
+			http.Error(w, "invalid admission review", http.StatusBadRequest)
+			return
+		}
+
+		request := review.Request
+		response := &admissionv1beta1.AdmissionResponse{Allowed: true}
+		if request.Kind.Kind == "Application" && request.Operation != admissionv1beta1.Delete {
+			app := new(v3.Application)
+			err = json.Unmarshal(request.Object.Raw, app)
+			if err != nil {
+				response = denied(metav1.StatusReasonBadRequest, fmt.Sprintf("decode application failed: %s", err.Error()))
+			} else if result := admit(app, request); result != nil {
+				response = result
+			}
+		}
+		response.UID = request.UID
+		review.Response = response
+		review.Request = nil
+
+		data, err := json.Marshal(review)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}
+}
+
+// validateApplication reject applications the controller can not render
+func validateApplication(app *v3.Application, request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	// metadata and status only updates, like the finalizer and fusing writes of the controller, pass even when
+	// the stored spec no longer satisfies newer rules
+	old := oldApplication(request)
+	if old != nil && reflect.DeepEqual(old.Spec, app.Spec) {
+		return nil
+	}
+	errs := controller.ValidateApplication(app)
+	if old != nil {
+		errs = append(errs, controller.ValidateApplicationUpdate(app, old)...)
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	log.Infof("Reject application %s: %s", request.Namespace+":"+app.Name, errs.ToAggregate().Error())
+	response := denied(metav1.StatusReasonInvalid, fmt.Sprintf("Application %q is invalid: %s", app.Name, errs.ToAggregate().Error()))
+	for _, err := range errs {
+		response.Result.Details.Causes = append(response.Result.Details.Causes, metav1.StatusCause{
+			Type:    metav1.CauseType(err.Type),
+			Message: err.ErrorBody(),
+			Field:   err.Field,
+		})
+	}
+	return response
+}
+
+// oldApplication the stored application of an UPDATE, nil for other operations
+func oldApplication(request *admissionv1beta1.AdmissionRequest) *v3.Application {
+	if request.Operation != admissionv1beta1.Update || len(request.OldObject.Raw) == 0 {
+		return nil
+	}
+	old := new(v3.Application)
+	if err := json.Unmarshal(request.OldObject.Raw, old); err != nil {
+		log.Errorf("Decode old application %s Error : %s", request.Namespace+":"+request.Name, err.Error())
+		return nil
+	}
+	return old
+}
+
+// defaultApplication write the defaults into spec, so the stored application is what gets deployed
+func defaultApplication(app *v3.Application, request *admissionv1beta1.AdmissionRequest) *admissionv1beta1.AdmissionResponse {
+	defaulted := app.DeepCopy()
+	controller.SetDefaults(defaulted)
+	if reflect.DeepEqual(app.Spec, defaulted.Spec) {
+		return nil
+	}
+	original, err := json.Marshal(app.Spec)
+	if err != nil {
+		return denied(metav1.StatusReasonInternalError, fmt.Sprintf("encode application failed: %s", err.Error()))
+	}
+	modified, err := json.Marshal(defaulted.Spec)
+	if err != nil {
+		return denied(metav1.StatusReasonInternalError, fmt.Sprintf("encode defaults failed: %s", err.Error()))
+	}
+	var from, to, raw interface{}
+	json.Unmarshal(original, &from)
+	json.Unmarshal(modified, &to)
+	json.Unmarshal(request.Object.Raw, &raw)
+	ops := jsonPatch("", from, to, nil)
+	// typed encoding has empty structs the submitted object may leave out, add their parents instead
+	rawSpec, _ := raw.(map[string]interface{})
+	ops = rootMissing(rawSpec["spec"], to, ops)
+	for _, op := range ops {
+		op["path"] = "/spec" + op["path"].(string)
+	}
+	patch, err := json.Marshal(ops)
+	if err != nil {
+		return denied(metav1.StatusReasonInternalError, fmt.Sprintf("encode defaults failed: %s", err.Error()))
+	}
+	log.Debugf("Default application %s: %s", request.Namespace+":"+app.Name, string(patch))
+	patchType := admissionv1beta1.PatchTypeJSONPatch
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patch,
+		PatchType: &patchType,
+	}
+}
+
+// jsonPatch list the add and replace operations turning from into to. Defaults never remove
+// values or list items, lists of the same length are compared item by item and replaced otherwise.
+func jsonPatch(path string, from, to interface{}, ops []map[string]interface{}) []map[string]interface{} {
+	switch t := to.(type) {
+	case map[string]interface{}:
+		f, ok := from.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			child := path + "/" + strings.Replace(strings.Replace(k, "~", "~0", -1), "/", "~1", -1)
+			if value, found := f[k]; found {
+				ops = jsonPatch(child, value, t[k], ops)
+			} else {
+				ops = append(ops, map[string]interface{}{"op": "add", "path": child, "value": t[k]})
+			}
+		}
+		return ops
+	case []interface{}:
+		f, ok := from.([]interface{})
+		if !ok || len(f) != len(t) {
+			break
+		}
+		for i := range t {
+			ops = jsonPatch(path+"/"+strconv.Itoa(i), f[i], t[i], ops)
+		}
+		return ops
+	}
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+	return append(ops, map[string]interface{}{"op": "replace", "path": path, "value": to})
+}
+
+// rootMissing move every operation whose parent is missing in raw up to the first missing
+// ancestor, adding the whole defaulted value there, and turn a replace of a missing leaf into an add
+func rootMissing(raw, to interface{}, ops []map[string]interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	added := make(map[string]bool)
+	for _, op := range ops {
+		segments := strings.Split(op["path"].(string), "/")[1:]
+		current, value := raw, to
+		for i, segment := range segments[:len(segments)-1] {
+			key := strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+			next, found := child(current, key)
+			value, _ = child(value, key)
+			if !found {
+				path := "/" + strings.Join(segments[:i+1], "/")
+				if !added[path] {
+					added[path] = true
+					result = append(result, map[string]interface{}{"op": "add", "path": path, "value": value})
+				}
+				op = nil
+				break
+			}
+			current = next
+		}
+		if op == nil {
+			continue
+		}
+		// fields without omitempty are in the typed encoding even when the submitted object leaves them out
+		leaf := strings.Replace(strings.Replace(segments[len(segments)-1], "~1", "/", -1), "~0", "~", -1)
+		if _, found := child(current, leaf); !found && op["op"] == "replace" {
+			op["op"] = "add"
+		}
+		result = append(result, op)
+	}
+	return result
+}
+
+func child(value interface{}, key string) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		c, ok := v[key]
+		return c, ok
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, false
+		}
+		return v[i], true
+	}
+	return nil, false
+}
+
+func denied(reason metav1.StatusReason, message string) *admissionv1beta1.AdmissionResponse {
+	code := int32(http.StatusBadRequest)
+	switch reason {
+	case metav1.StatusReasonInvalid:
+		code = http.StatusUnprocessableEntity
+	case metav1.StatusReasonInternalError:
+		code = http.StatusInternalServerError
+	}
+	return &admissionv1beta1.AdmissionResponse{
+		Allowed: false,
+		Result: &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Reason:  reason,
+			Message: message,
+			Code:    code,
+			Details: &metav1.StatusDetails{Kind: "Application"},
+		},
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/hd-Li/application/controller"
+	v3 "github.com/hd-Li/types/apis/project.cattle.io/v3"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// newAdmissionRequest the request of submitting raw, decoded like serveAdmission does
+func newAdmissionRequest(t *testing.T, operation admissionv1beta1.Operation, raw, oldRaw string) (*v3.Application, *admissionv1beta1.AdmissionRequest) {
+	request := &admissionv1beta1.AdmissionRequest{
+		Operation: operation,
+		Namespace: "team",
+		Object:    runtime.RawExtension{Raw: []byte(raw)},
+	}
+	if oldRaw != "" {
+		request.OldObject = runtime.RawExtension{Raw: []byte(oldRaw)}
+	}
+	app := new(v3.Application)
+	if err := json.Unmarshal([]byte(raw), app); err != nil {
+		t.Fatal(err)
+	}
+	return app, request
+}
+
+func TestDefaultApplication(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		// noPatch the submitted application has every default
+		noPatch bool
+	}{
+		{name: "minimal", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1","containers":[{"name":"web","image":"nginx"}]}]}}`},
+		{name: "resources partly set", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+			"containers":[{"name":"web","image":"nginx","resources":{"cpu":"1"}}]}]}}`},
+		{name: "empty values", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+			"containers":[{"name":"web","image":"nginx","resources":{"cpu":"","memory":""}}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`},
+		{name: "several containers and components", raw: `{"metadata":{"name":"demo"},"spec":{"components":[
+			{"name":"web","version":"v1","containers":[{"name":"web","image":"nginx","resources":{"memory":"1Gi"}},{"name":"log","image":"fluentd"}]},
+			{"name":"api","version":"v2","containers":[{"name":"api","image":"api"}]}]}}`},
+		{name: "trusted workload", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1"}]}}`},
+		{name: "opt traits partly set", raw: `{"metadata":{"name":"demo"},"spec":{"components":[],
+			"optTraits":{"httpretry":{"attempts":5,"perTryTimeout":"2s"}}}}`},
+		{name: "gpu and a removed default", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+			"containers":[{"name":"web","image":"nginx","resources":{"gpu":1}}],"componentTraits":{"schedulePolicy":{"nodeSelector":{"user":""}}}}]}}`},
+		{name: "daemonset", raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"agent","version":"v1","workloadType":"DaemonSet",
+			"containers":[{"name":"agent","image":"agent"}]}]}}`},
+		{name: "everything set", noPatch: true, raw: `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+			"containers":[{"name":"web","image":"nginx","resources":{"cpu":"1","memory":"1Gi"}}],
+			"componentTraits":{"schedulePolicy":{"nodeSelector":{"type":"cpu","user":"SP"}}}}],
+			"optTraits":{"loadBalancer":{"simple":"LEAST_CONN"},"httpretry":{"attempts":5,"perTryTimeout":"2s"}}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, request := newAdmissionRequest(t, admissionv1beta1.Create, tt.raw, "")
+			want := app.DeepCopy()
+			controller.SetDefaults(want)
+
+			response := defaultApplication(app, request)
+			if tt.noPatch {
+				if response != nil {
+					t.Errorf("defaultApplication patched %s, want no patch", response.Patch)
+				}
+				return
+			}
+			if response == nil || !response.Allowed || response.PatchType == nil || *response.PatchType != admissionv1beta1.PatchTypeJSONPatch {
+				t.Fatalf("defaultApplication = %+v, want an allowed json patch", response)
+			}
+			patch, err := jsonpatch.DecodePatch(response.Patch)
+			if err != nil {
+				t.Fatalf("decode patch %s: %s", response.Patch, err.Error())
+			}
+			// the apiserver applies the patch to the submitted object, not to its typed encoding
+			patched, err := patch.Apply([]byte(tt.raw))
+			if err != nil {
+				t.Fatalf("apply patch %s: %s", response.Patch, err.Error())
+			}
+			got := new(v3.Application)
+			if err := json.Unmarshal(patched, got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Spec, want.Spec) {
+				t.Errorf("patched spec\n%+v\nwant\n%+v\npatch %s", got.Spec, want.Spec, response.Patch)
+			}
+		})
+	}
+}
+
+func TestJSONPatch(t *testing.T) {
+	decode := func(s string) interface{} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(s), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "equal", from: `{"a":1}`, to: `{"a":1}`, want: `null`},
+		{name: "added", from: `{}`, to: `{"a":{"b":1}}`, want: `[{"op":"add","path":"/a","value":{"b":1}}]`},
+		{name: "replaced", from: `{"a":""}`, to: `{"a":"x"}`, want: `[{"op":"replace","path":"/a","value":"x"}]`},
+		{name: "never removed", from: `{"a":1,"b":2}`, to: `{"a":1}`, want: `null`},
+		{name: "escaped keys", from: `{}`, to: `{"a/b~c":1}`, want: `[{"op":"add","path":"/a~1b~0c","value":1}]`},
+		{name: "list items", from: `[{"a":1},{}]`, to: `[{"a":1},{"b":2}]`, want: `[{"op":"add","path":"/1/b","value":2}]`},
+		{name: "list length", from: `[1]`, to: `[1,2]`, want: `[{"op":"replace","path":"","value":[1,2]}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(jsonPatch("", decode(tt.from), decode(tt.to), nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("jsonPatch = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRootMissing operations are turned into adds the submitted object, which may leave fields out, accepts
+func TestRootMissing(t *testing.T) {
+	decode := func(s string) interface{} {
+		var value interface{}
+		if err := json.Unmarshal([]byte(s), &value); err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	tests := []struct {
+		name    string
+		raw, to string
+		ops     []map[string]interface{}
+		want    string
+	}{
+		{name: "parent present", raw: `{"a":{"b":""}}`, to: `{"a":{"b":"x"}}`,
+			ops:  []map[string]interface{}{{"op": "replace", "path": "/a/b", "value": "x"}},
+			want: `[{"op":"replace","path":"/a/b","value":"x"}]`},
+		{name: "missing leaf replaced", raw: `{"a":{}}`, to: `{"a":{"b":"x"}}`,
+			ops:  []map[string]interface{}{{"op": "replace", "path": "/a/b", "value": "x"}},
+			want: `[{"op":"add","path":"/a/b","value":"x"}]`},
+		{name: "missing parent", raw: `{}`, to: `{"a":{"b":"x","c":"y"}}`,
+			ops:  []map[string]interface{}{{"op": "add", "path": "/a/b", "value": "x"}, {"op": "add", "path": "/a/c", "value": "y"}},
+			want: `[{"op":"add","path":"/a","value":{"b":"x","c":"y"}}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(rootMissing(decode(tt.raw), decode(tt.to), tt.ops))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("rootMissing = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateApplicationWebhook(t *testing.T) {
+	const valid = `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+		"containers":[{"name":"web","image":"nginx"}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`
+	const invalid = `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1",
+		"containers":[{"name":"web","image":""}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`
+	const invalidLabeled = `{"metadata":{"name":"demo","labels":{"a":"b"}},"spec":{"components":[{"name":"web","version":"v1",
+		"containers":[{"name":"web","image":""}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`
+	const statefulset = `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1","workloadType":"StatefulSet",
+		"containers":[{"name":"web","image":"nginx"}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`
+	const parallel = `{"metadata":{"name":"demo"},"spec":{"components":[{"name":"web","version":"v1","workloadType":"StatefulSet",
+		"workloadSetings":[{"name":"podManagementPolicy","type":"string","value":"Parallel"}],
+		"containers":[{"name":"web","image":"nginx"}]}],"optTraits":{"ingress":{"host":"demo","serverPort":80}}}}`
+	tests := []struct {
+		name      string
+		operation admissionv1beta1.Operation
+		raw, old  string
+		allowed   bool
+	}{
+		{name: "valid create", operation: admissionv1beta1.Create, raw: valid, allowed: true},
+		{name: "invalid create", operation: admissionv1beta1.Create, raw: invalid, allowed: false},
+		{name: "metadata only update of an invalid spec", operation: admissionv1beta1.Update, raw: invalidLabeled, old: invalid, allowed: true},
+		{name: "invalid update", operation: admissionv1beta1.Update, raw: invalid, old: valid, allowed: false},
+		{name: "immutable statefulset field", operation: admissionv1beta1.Update, raw: parallel, old: statefulset, allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, request := newAdmissionRequest(t, tt.operation, tt.raw, tt.old)
+			response := validateApplication(app, request)
+			if allowed := response == nil || response.Allowed; allowed != tt.allowed {
+				t.Errorf("validateApplication allowed = %v, want %v: %+v", allowed, tt.allowed, response)
+			}
+			if !tt.allowed && len(response.Result.Details.Causes) == 0 {
+				t.Errorf("validateApplication denied without causes: %+v", response.Result)
+			}
+		})
+	}
+}